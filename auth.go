@@ -0,0 +1,181 @@
+package apttransports3go
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/rs/zerolog"
+)
+
+// parseNetrc parses apt's auth.conf and returns the credentials by machine.
+// The first entry for a machine takes precedence, as apt does.
+func parseNetrc(r io.Reader) map[string]aws.Credentials {
+	scanner := bufio.NewScanner(r)
+	scanner.Split(bufio.ScanWords)
+	entries := map[string]aws.Credentials{}
+	var machine string
+	var creds aws.Credentials
+
+	flush := func() {
+		if machine == "" {
+			return
+		}
+
+		if _, ok := entries[machine]; !ok && creds.AccessKeyID != "" && creds.SecretAccessKey != "" {
+			entries[machine] = creds
+		}
+
+		machine = ""
+		creds = aws.Credentials{}
+	}
+
+	for scanner.Scan() {
+		switch scanner.Text() {
+		case "machine":
+			flush()
+
+			if scanner.Scan() {
+				machine = strings.TrimSuffix(strings.TrimPrefix(scanner.Text(), "s3://"), "/")
+			}
+		case "login":
+			if scanner.Scan() {
+				creds.AccessKeyID = scanner.Text()
+			}
+		case "password":
+			if scanner.Scan() {
+				creds.SecretAccessKey = scanner.Text()
+			}
+		}
+	}
+
+	flush()
+	return entries
+}
+
+func readAuthConf(ctx context.Context, authConf string, authConfParts string) map[string]aws.Credentials {
+	logger := zerolog.Ctx(ctx)
+	paths := []string{authConf}
+	parts, _ := filepath.Glob(filepath.Join(authConfParts, "*.conf"))
+	sort.Strings(parts)
+	paths = append(paths, parts...)
+	entries := map[string]aws.Credentials{}
+
+	for _, path := range paths {
+		f, err := os.Open(path)
+
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				logger.Warn().Err(err).Str("path", path).Msg("failed to read auth.conf")
+			}
+
+			continue
+		}
+
+		for machine, creds := range parseNetrc(f) {
+			if _, ok := entries[machine]; !ok {
+				entries[machine] = creds
+			}
+		}
+
+		f.Close()
+	}
+
+	return entries
+}
+
+// time to wait for "602 Authorization Credentials", which apt without a handler for 402 never sends
+var authorizationTimeout = 10 * time.Second
+
+var errNoCredentials = errors.New("no authorization credentials")
+
+// authorizer asks apt for credentials with "402 Authorization Required".
+type authorizer struct {
+	w     io.Writer
+	mu    sync.Mutex
+	reply chan map[string][]string
+	done  chan struct{}
+	once  sync.Once
+	// serialize requests because replies have no request ID
+	reqMu sync.Mutex
+}
+
+func newAuthorizer(w io.Writer) *authorizer {
+	return &authorizer{
+		w:    w,
+		done: make(chan struct{}),
+	}
+}
+
+func (a *authorizer) authorize(ctx context.Context, bucket string) (aws.CredentialsProvider, error) {
+	a.reqMu.Lock()
+	defer a.reqMu.Unlock()
+	reply := make(chan map[string][]string, 1)
+	a.mu.Lock()
+	a.reply = reply
+	a.mu.Unlock()
+
+	// a late reply must not be passed to the next request
+	defer func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+
+		if a.reply == reply {
+			a.reply = nil
+		}
+	}()
+
+	zerolog.Ctx(ctx).Debug().Str("bucket", bucket).Msg("request credentials")
+	send(ctx, a.w, StatusAuthorizationRequired, map[string]string{"Site": bucket})
+	var header map[string][]string
+	timer := time.NewTimer(authorizationTimeout)
+	defer timer.Stop()
+
+	select {
+	case header = <-reply:
+	case <-a.done:
+		return nil, errNoCredentials
+	case <-timer.C:
+		return nil, fmt.Errorf("%w: no reply to 402 Authorization Required in %s", errNoCredentials, authorizationTimeout)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	user := header["User"]
+	password := header["Password"]
+
+	if len(user) == 0 || user[0] == "" || len(password) == 0 || password[0] == "" {
+		return nil, errNoCredentials
+	}
+
+	return credentials.NewStaticCredentialsProvider(user[0], password[0], ""), nil
+}
+
+// receive passes "602 Authorization Credentials" to the waiting request.
+func (a *authorizer) receive(ctx context.Context, header map[string][]string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.reply == nil {
+		zerolog.Ctx(ctx).Warn().Msg("ignore unexpected authorization credentials")
+		return
+	}
+
+	a.reply <- header
+	a.reply = nil
+}
+
+// close makes waiting and further requests fail once apt has no more messages to send.
+func (a *authorizer) close() {
+	a.once.Do(func() { close(a.done) })
+}
@@ -0,0 +1,53 @@
+package apttransports3go_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	apttransports3go "github.com/winebarrel/apt-transport-s3-go"
+)
+
+func TestParseNetrc_OK(t *testing.T) {
+	assert := assert.New(t)
+	netrc := `machine my-bucket
+login AKIAMYBUCKET
+password my-secret
+
+machine s3://other-bucket/ login AKIAOTHERBUCKET password other-secret
+machine my-bucket login AKIADUPLICATED password duplicated
+machine no-password login AKIANOPASSWORD
+`
+
+	assert.Equal(map[string]aws.Credentials{
+		"my-bucket":    {AccessKeyID: "AKIAMYBUCKET", SecretAccessKey: "my-secret"},
+		"other-bucket": {AccessKeyID: "AKIAOTHERBUCKET", SecretAccessKey: "other-secret"},
+	}, apttransports3go.ParseNetrc(strings.NewReader(netrc)))
+}
+
+func TestReadAuthConf_OK(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "auth.conf.d"), 0700) //nolint:errcheck
+
+	files := map[string]string{
+		"auth.conf":                       "machine my-bucket login AKIAAUTHCONF password secret",
+		"auth.conf.d/10-s3.conf":          "machine my-bucket login AKIAPARTS password secret\nmachine other-bucket login AKIAOTHER password secret",
+		"auth.conf.d/20-s3.conf.disabled": "machine third-bucket login AKIATHIRD password secret",
+	}
+
+	for name, content := range files {
+		os.WriteFile(filepath.Join(dir, name), []byte(content), 0600) //nolint:errcheck
+	}
+
+	ctx := log.Logger.WithContext(context.Background())
+	assert.Equal(map[string]aws.Credentials{
+		"my-bucket":    {AccessKeyID: "AKIAAUTHCONF", SecretAccessKey: "secret"},
+		"other-bucket": {AccessKeyID: "AKIAOTHER", SecretAccessKey: "secret"},
+	}, apttransports3go.ReadAuthConf(ctx, filepath.Join(dir, "auth.conf"), filepath.Join(dir, "auth.conf.d")))
+}
@@ -0,0 +1,203 @@
+package apttransports3go
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/rs/zerolog"
+)
+
+// cachePath returns the path of the cache entry for the object version.
+func cachePath(dir string, bucket string, key string, etag string) string {
+	sum := sha256.Sum256([]byte(bucket + "/" + key + "\x00" + etag))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(dir, name[:2], name)
+}
+
+// etagMD5 returns the MD5 of the object from its ETag,
+// which is the case only for objects uploaded in a single part without SSE-KMS or SSE-C.
+func etagMD5(objHead *s3.HeadObjectOutput) string {
+	etag := strings.Trim(aws.ToString(objHead.ETag), `"`)
+
+	// the ETag of a multipart upload is like "<md5 of the part md5s>-<parts>"
+	if len(etag) != 32 || strings.Contains(etag, "-") {
+		return ""
+	}
+
+	switch objHead.ServerSideEncryption {
+	case types.ServerSideEncryptionAwsKms, types.ServerSideEncryptionAwsKmsDsse:
+		return ""
+	}
+
+	if aws.ToString(objHead.SSECustomerAlgorithm) != "" {
+		return ""
+	}
+
+	if _, err := hex.DecodeString(etag); err != nil {
+		return ""
+	}
+
+	return strings.ToLower(etag)
+}
+
+// fetchCache serves the object from the cache entry if it is there and intact.
+// The entry must be verified by the expected hashes, the additional checksum or the ETag,
+// otherwise the object is downloaded again.
+func fetchCache(ctx context.Context, w io.Writer, req *fetchRequest, cached string, objHead *s3.HeadObjectOutput) bool {
+	logger := zerolog.Ctx(ctx).With().Str("cache", cached).Logger()
+	fp, err := os.Open(cached)
+
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warn().Err(err).Msg("failed to open cache")
+		}
+
+		return false
+	}
+
+	defer fp.Close()
+	fi, err := fp.Stat()
+
+	if err != nil || fi.Size() != aws.ToInt64(objHead.ContentLength) {
+		logger.Debug().Msg("ignore broken cache")
+		return false
+	}
+
+	hs := newHashSums()
+	checksum := headObjectChecksum(objHead, hs)
+	md5 := etagMD5(objHead)
+	_, hasSHA512 := req.header["Expected-SHA512"]
+	_, hasSHA256 := req.header["Expected-SHA256"]
+	_, hasMD5Sum := req.header["Expected-MD5Sum"]
+
+	if !hasSHA512 && !hasSHA256 && !hasMD5Sum && checksum == nil && md5 == "" {
+		logger.Debug().Msg("ignore unverifiable cache")
+		return false
+	}
+
+	var hw io.Writer = hs
+
+	if checksum != nil {
+		hw = io.MultiWriter(hs, checksum)
+	}
+
+	if _, err := io.Copy(hw, fp); err != nil {
+		logger.Warn().Err(err).Msg("failed to read cache")
+		return false
+	}
+
+	err = verifyHashes(req.header, hs.sums())
+
+	if err == nil && checksum != nil {
+		err = checksum.verify()
+	}
+
+	if err == nil && md5 != "" && md5 != hs.sums()["MD5Sum"] {
+		err = fmt.Errorf("hash sum mismatch: ETag expected %s, but got %s", md5, hs.sums()["MD5Sum"])
+	}
+
+	if err != nil {
+		logger.Warn().Err(err).Msg("ignore cache")
+		return false
+	}
+
+	size := fi.Size()
+	lastModified := aws.ToTime(objHead.LastModified)
+
+	if err := installFile(cached, req.fn, lastModified); err != nil {
+		logger.Warn().Err(err).Msg("failed to copy cache")
+		return false
+	}
+
+	send(ctx, w, StatusURIStart, map[string]string{
+		"URI":           req.uri,
+		"Size":          strconv.FormatInt(size, 10),
+		"Last-Modified": lastModified.UTC().Format(time.RFC1123),
+	})
+
+	logger.Debug().Msg("cache hit")
+	sendDone(ctx, w, req, size, lastModified, hs)
+	return true
+}
+
+// storeCache adds the downloaded file to the cache.
+func storeCache(ctx context.Context, fn string, cached string, lastModified time.Time) {
+	logger := zerolog.Ctx(ctx).With().Str("cache", cached).Logger()
+
+	if err := os.MkdirAll(filepath.Dir(cached), 0755); err != nil {
+		logger.Warn().Err(err).Msg("failed to create cache directory")
+		return
+	}
+
+	if err := installFile(fn, cached, lastModified); err != nil {
+		logger.Warn().Err(err).Msg("failed to store cache")
+		return
+	}
+
+	logger.Debug().Msg("store cache")
+}
+
+// installFile hardlinks src to dst, or copies it when they are on different filesystems.
+func installFile(src string, dst string, mtime time.Time) error {
+	if err := linkFile(src, dst); err == nil {
+		return nil
+	}
+
+	return copyFile(src, dst, mtime)
+}
+
+func linkFile(src string, dst string) error {
+	// reserve a unique temporary name next to dst, then replace dst by rename
+	fp, err := createAtomicFile(dst)
+
+	if err != nil {
+		return err
+	}
+
+	defer fp.cleanup()
+	fp.Close()
+
+	if err := os.Remove(fp.Name()); err != nil {
+		return err
+	}
+
+	if err := os.Link(src, fp.Name()); err != nil {
+		return err
+	}
+
+	return os.Rename(fp.Name(), dst)
+}
+
+func copyFile(src string, dst string, mtime time.Time) error {
+	in, err := os.Open(src)
+
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+	fp, err := createAtomicFile(dst)
+
+	if err != nil {
+		return err
+	}
+
+	defer fp.cleanup()
+
+	if _, err := io.Copy(fp, in); err != nil {
+		return err
+	}
+
+	return fp.commit(mtime)
+}
@@ -0,0 +1,239 @@
+package apttransports3go_test
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	apttransports3go "github.com/winebarrel/apt-transport-s3-go"
+)
+
+func newCacheMock(body string) *MockS3API {
+	return &MockS3API{
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: len(body),
+		LastModified:  timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00"),
+		ETag:          `"etag"`,
+	}
+}
+
+func TestFetch_CacheMiss(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	dl := filepath.Join(dir, "key")
+	header := map[string][]string{
+		"URI":      {"s3://example.com/key"},
+		"Filename": {dl},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	cfg := apttransports3go.NewConfig()
+	cfg.Global.CacheDir = filepath.Join(dir, "cache")
+	api := newCacheMock("apt body")
+	err := apttransports3go.Fetch(ctx, &buf, api, cfg, header)
+
+	assert.NoError(err)
+	assert.Contains(buf.String(), "201 URI Done\n")
+	assert.NotNil(api.HeadObjectInput)
+	assert.Equal(`"etag"`, *api.GetObjectInput.IfMatch)
+
+	entries, _ := filepath.Glob(filepath.Join(cfg.Global.CacheDir, "*", "*"))
+	assert.Len(entries, 1)
+	cached, _ := os.ReadFile(entries[0])
+	assert.Equal("apt body", string(cached))
+	fi, _ := os.Stat(entries[0])
+	assert.True(api.LastModified.Equal(fi.ModTime()))
+}
+
+func TestFetch_CacheHit(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	ctx := log.Logger.WithContext(context.Background())
+	cfg := apttransports3go.NewConfig()
+	cfg.Global.CacheDir = filepath.Join(dir, "cache")
+	err := apttransports3go.Fetch(ctx, io.Discard, newCacheMock("apt body"), cfg, map[string][]string{
+		"URI":      {"s3://example.com/key"},
+		"Filename": {filepath.Join(dir, "first")},
+	})
+	assert.NoError(err)
+
+	dl := filepath.Join(dir, "second")
+	header := map[string][]string{
+		"URI":             {"s3://example.com/key"},
+		"Filename":        {dl},
+		"Expected-SHA256": {"53ce64325a3802023c1922d1eda5a1d67c1183c31ba509277cfa6350d01cdd85"},
+	}
+
+	var buf strings.Builder
+	api := newCacheMock("apt body")
+	err = apttransports3go.Fetch(ctx, &buf, api, cfg, header)
+
+	assert.NoError(err)
+	assert.Equal(`102 Status
+Message: Waiting for headers
+URI: s3://example.com/key
+
+200 URI Start
+Last-Modified: Sun, 20 Nov 2022 12:34:56 UTC
+Size: 8
+URI: s3://example.com/key
+
+201 URI Done
+Filename: `+dl+`
+Last-Modified: Sun, 20 Nov 2022 12:34:56 UTC
+MD5-Hash: 600c0724d390c99d2db510c260402a50
+MD5Sum-Hash: 600c0724d390c99d2db510c260402a50
+SHA256-Hash: 53ce64325a3802023c1922d1eda5a1d67c1183c31ba509277cfa6350d01cdd85
+SHA512-Hash: e62d8d35da15710e6940c5ed201ddcd1f3debb04879ddd95e091084880b17d3b6c879c019389bd3e49e697c0d58ad14f0358da41f0a9e304eab1319ff1b4e5e3
+Size: 8
+URI: s3://example.com/key
+
+`, buf.String())
+	assert.NotNil(api.HeadObjectInput)
+	assert.Nil(api.GetObjectInput)
+	content, _ := os.ReadFile(dl)
+	assert.Equal("apt body", string(content))
+}
+
+func TestFetch_CacheChangedObject(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	ctx := log.Logger.WithContext(context.Background())
+	cfg := apttransports3go.NewConfig()
+	cfg.Global.CacheDir = filepath.Join(dir, "cache")
+	err := apttransports3go.Fetch(ctx, io.Discard, newCacheMock("apt body"), cfg, map[string][]string{
+		"URI":      {"s3://example.com/key"},
+		"Filename": {filepath.Join(dir, "first")},
+	})
+	assert.NoError(err)
+
+	dl := filepath.Join(dir, "second")
+	header := map[string][]string{
+		"URI":      {"s3://example.com/key"},
+		"Filename": {dl},
+	}
+
+	api := newCacheMock("new body")
+	api.ETag = `"new-etag"`
+	err = apttransports3go.Fetch(ctx, io.Discard, api, cfg, header)
+
+	assert.NoError(err)
+	assert.NotNil(api.GetObjectInput)
+	content, _ := os.ReadFile(dl)
+	assert.Equal("new body", string(content))
+	entries, _ := filepath.Glob(filepath.Join(cfg.Global.CacheDir, "*", "*"))
+	assert.Len(entries, 2)
+}
+
+func TestFetch_CacheCorrupted(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	ctx := log.Logger.WithContext(context.Background())
+	cfg := apttransports3go.NewConfig()
+	cfg.Global.CacheDir = filepath.Join(dir, "cache")
+	err := apttransports3go.Fetch(ctx, io.Discard, newCacheMock("apt body"), cfg, map[string][]string{
+		"URI":      {"s3://example.com/key"},
+		"Filename": {filepath.Join(dir, "first")},
+	})
+	assert.NoError(err)
+
+	entries, _ := filepath.Glob(filepath.Join(cfg.Global.CacheDir, "*", "*"))
+	os.Remove(entries[0])
+	os.WriteFile(entries[0], []byte("apt BODY"), 0644) //nolint:errcheck
+
+	dl := filepath.Join(dir, "second")
+	header := map[string][]string{
+		"URI":             {"s3://example.com/key"},
+		"Filename":        {dl},
+		"Expected-SHA256": {"53ce64325a3802023c1922d1eda5a1d67c1183c31ba509277cfa6350d01cdd85"},
+	}
+
+	var buf strings.Builder
+	api := newCacheMock("apt body")
+	err = apttransports3go.Fetch(ctx, &buf, api, cfg, header)
+
+	assert.NoError(err)
+	assert.Contains(buf.String(), "201 URI Done\n")
+	assert.NotNil(api.GetObjectInput)
+	content, _ := os.ReadFile(dl)
+	assert.Equal("apt body", string(content))
+	cached, _ := os.ReadFile(entries[0])
+	assert.Equal("apt body", string(cached))
+}
+
+// aptBodyETag is the MD5 of "apt body", which is the ETag of a single part upload
+const aptBodyETag = `"600c0724d390c99d2db510c260402a50"`
+
+// fetchCacheTwice stores the object in the cache and fetches it again without expected hashes.
+func fetchCacheTwice(t *testing.T, api *MockS3API, corrupt bool) *MockS3API {
+	dir := t.TempDir()
+	ctx := log.Logger.WithContext(context.Background())
+	cfg := apttransports3go.NewConfig()
+	cfg.Global.CacheDir = filepath.Join(dir, "cache")
+	first := newCacheMock("apt body")
+	first.ETag = api.ETag
+	err := apttransports3go.Fetch(ctx, io.Discard, first, cfg, map[string][]string{
+		"URI":      {"s3://example.com/key"},
+		"Filename": {filepath.Join(dir, "first")},
+	})
+	assert.NoError(t, err)
+
+	entries, _ := filepath.Glob(filepath.Join(cfg.Global.CacheDir, "*", "*"))
+	assert.Len(t, entries, 1)
+
+	if corrupt {
+		os.Remove(entries[0])
+		os.WriteFile(entries[0], []byte("apt BODY"), 0644) //nolint:errcheck
+	}
+
+	dl := filepath.Join(dir, "second")
+	err = apttransports3go.Fetch(ctx, io.Discard, api, cfg, map[string][]string{
+		"URI":      {"s3://example.com/key"},
+		"Filename": {dl},
+	})
+	assert.NoError(t, err)
+	content, _ := os.ReadFile(dl)
+	assert.Equal(t, "apt body", string(content))
+	return api
+}
+
+func TestFetch_CacheHitVerifiedByETag(t *testing.T) {
+	api := newCacheMock("apt body")
+	api.ETag = aptBodyETag
+	fetchCacheTwice(t, api, false)
+	assert.Nil(t, api.GetObjectInput)
+}
+
+func TestFetch_CacheCorruptedVerifiedByETag(t *testing.T) {
+	api := newCacheMock("apt body")
+	api.ETag = aptBodyETag
+	fetchCacheTwice(t, api, true)
+	assert.NotNil(t, api.GetObjectInput)
+}
+
+func TestFetch_CacheUnverifiable(t *testing.T) {
+	// neither expected hashes, a checksum nor an MD5 ETag
+	for _, etag := range []string{`"etag"`, `"600c0724d390c99d2db510c260402a50-2"`} {
+		api := newCacheMock("apt body")
+		api.ETag = etag
+		fetchCacheTwice(t, api, false)
+		assert.NotNil(t, api.GetObjectInput, etag)
+	}
+}
+
+func TestFetch_CacheUnverifiableSSEKMS(t *testing.T) {
+	// the ETag of an SSE-KMS object is not its MD5
+	api := newCacheMock("apt body")
+	api.ETag = aptBodyETag
+	api.ServerSideEncryption = types.ServerSideEncryptionAwsKms
+	fetchCacheTwice(t, api, false)
+	assert.NotNil(t, api.GetObjectInput)
+}
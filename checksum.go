@@ -0,0 +1,127 @@
+package apttransports3go
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// CRC-64/NVME in the reversed form as hash/crc64 expects
+var crc64NVMETable = crc64.MakeTable(0x9a6c9329ac4bc9b5)
+
+// objectChecksum verifies the full-object checksum that S3 stores with the object.
+type objectChecksum struct {
+	algorithm string
+	expected  []byte
+	hash      hash.Hash
+	// SHA256 reported to apt, which the SHA256 checksum is checked against instead of hash
+	hs *hashSums
+}
+
+// newObjectChecksum returns the checksum to verify, or nil if the object has no full-object checksum.
+func newObjectChecksum(checksumType types.ChecksumType, checksums map[string]*string, hs *hashSums) *objectChecksum {
+	// a composite checksum of a multipart upload (e.g. "...-3") is not the checksum of the whole object
+	if checksumType == types.ChecksumTypeComposite {
+		return nil
+	}
+
+	// check the strongest checksum first
+	for _, algorithm := range []string{"SHA256", "SHA1", "CRC64NVME", "CRC32C", "CRC32"} {
+		value := aws.ToString(checksums[algorithm])
+
+		if value == "" || strings.Contains(value, "-") {
+			continue
+		}
+
+		expected, err := base64.StdEncoding.DecodeString(value)
+
+		if err != nil {
+			continue
+		}
+
+		oc := &objectChecksum{algorithm: algorithm, expected: expected}
+
+		switch algorithm {
+		case "SHA256":
+			if hs != nil {
+				oc.hs = hs
+			} else {
+				oc.hash = sha256.New()
+			}
+		case "SHA1":
+			oc.hash = sha1.New()
+		case "CRC64NVME":
+			oc.hash = crc64.New(crc64NVMETable)
+		case "CRC32C":
+			oc.hash = crc32.New(crc32.MakeTable(crc32.Castagnoli))
+		case "CRC32":
+			oc.hash = crc32.NewIEEE()
+		}
+
+		return oc
+	}
+
+	return nil
+}
+
+func getObjectChecksum(obj *s3.GetObjectOutput, hs *hashSums) *objectChecksum {
+	return newObjectChecksum(obj.ChecksumType, map[string]*string{
+		"SHA256":    obj.ChecksumSHA256,
+		"SHA1":      obj.ChecksumSHA1,
+		"CRC64NVME": obj.ChecksumCRC64NVME,
+		"CRC32C":    obj.ChecksumCRC32C,
+		"CRC32":     obj.ChecksumCRC32,
+	}, hs)
+}
+
+func headObjectChecksum(obj *s3.HeadObjectOutput, hs *hashSums) *objectChecksum {
+	return newObjectChecksum(obj.ChecksumType, map[string]*string{
+		"SHA256":    obj.ChecksumSHA256,
+		"SHA1":      obj.ChecksumSHA1,
+		"CRC64NVME": obj.ChecksumCRC64NVME,
+		"CRC32C":    obj.ChecksumCRC32C,
+		"CRC32":     obj.ChecksumCRC32,
+	}, hs)
+}
+
+func (oc *objectChecksum) Write(p []byte) (int, error) {
+	if oc.hash != nil {
+		oc.hash.Write(p)
+	}
+
+	return len(p), nil
+}
+
+func (oc *objectChecksum) verify() error {
+	var actual []byte
+
+	if oc.hs != nil {
+		actual = oc.hs.sha256.Sum(nil)
+	} else {
+		actual = oc.hash.Sum(nil)
+	}
+
+	if bytes.Equal(oc.expected, actual) {
+		return nil
+	}
+
+	// show SHA256 in hex like SHA256-Hash
+	encode := base64.StdEncoding.EncodeToString
+
+	if oc.algorithm == "SHA256" {
+		encode = hex.EncodeToString
+	}
+
+	return fmt.Errorf("checksum mismatch: Checksum%s expected %s, but got %s", oc.algorithm, encode(oc.expected), encode(actual))
+}
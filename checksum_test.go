@@ -0,0 +1,225 @@
+package apttransports3go_test
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	apttransports3go "github.com/winebarrel/apt-transport-s3-go"
+)
+
+const (
+	// checksums of "apt body"
+	aptBodySHA256 = "U85kMlo4AgI8GSLR7aWh1nwRg8MbpQknfPpjUNAc3YU="
+	aptBodyCRC32C = "hVpiiA=="
+	// ChecksumSHA256 of "apt babe"
+	otherSHA256 = "YpobxdwhV2FMisMKmQ6G4Rtr8dw2eZyPVnb6Tx46y9k="
+)
+
+func TestFetch_ChecksumSHA256(t *testing.T) {
+	assert := assert.New(t)
+	dl := filepath.Join(t.TempDir(), "key")
+	header := map[string][]string{
+		"URI":      {"s3://example.com/key"},
+		"Filename": {dl},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	api := &MockS3API{
+		Content:        "apt body",
+		LastModified:   timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00"),
+		ChecksumSHA256: aptBodySHA256,
+		ChecksumType:   types.ChecksumTypeFullObject,
+	}
+	err := apttransports3go.Fetch(ctx, &buf, api, apttransports3go.NewConfig(), header)
+
+	assert.NoError(err)
+	assert.Contains(buf.String(), "201 URI Done\n")
+	assert.Equal(types.ChecksumModeEnabled, api.GetObjectInput.ChecksumMode)
+}
+
+func TestFetch_ChecksumSHA256Mismatch(t *testing.T) {
+	assert := assert.New(t)
+	dl := filepath.Join(t.TempDir(), "key")
+	header := map[string][]string{
+		"URI":      {"s3://example.com/key"},
+		"Filename": {dl},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	err := apttransports3go.Fetch(ctx, &buf, &MockS3API{
+		Content:        "apt body",
+		LastModified:   timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00"),
+		ChecksumSHA256: otherSHA256,
+	}, apttransports3go.NewConfig(), header)
+
+	assert.NoError(err)
+	assert.Contains(buf.String(), `400 URI Failure
+FailReason: HashSumMismatch
+Message: checksum mismatch: ChecksumSHA256 expected 629a1bc5dc2157614c8ac30a990e86e11b6bf1dc36799c8f5676fa4f1e3acbd9, but got 53ce64325a3802023c1922d1eda5a1d67c1183c31ba509277cfa6350d01cdd85
+`)
+	assert.NoFileExists(dl)
+}
+
+func TestFetch_ChecksumCRC32CMismatch(t *testing.T) {
+	assert := assert.New(t)
+	dl := filepath.Join(t.TempDir(), "key")
+	header := map[string][]string{
+		"URI":      {"s3://example.com/key"},
+		"Filename": {dl},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	err := apttransports3go.Fetch(ctx, &buf, &MockS3API{
+		Content:        "apt babe",
+		LastModified:   timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00"),
+		ChecksumCRC32C: aptBodyCRC32C,
+	}, apttransports3go.NewConfig(), header)
+
+	assert.NoError(err)
+	assert.Contains(buf.String(), "FailReason: HashSumMismatch\nMessage: checksum mismatch: ChecksumCRC32C expected hVpiiA==, but got ")
+	assert.NoFileExists(dl)
+}
+
+func TestFetch_ChecksumComposite(t *testing.T) {
+	assert := assert.New(t)
+	dl := filepath.Join(t.TempDir(), "key")
+	header := map[string][]string{
+		"URI":      {"s3://example.com/key"},
+		"Filename": {dl},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	err := apttransports3go.Fetch(ctx, &buf, &MockS3API{
+		Content:        "apt body",
+		LastModified:   timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00"),
+		ChecksumCRC32C: "AAAAAA==-2",
+		ChecksumType:   types.ChecksumTypeComposite,
+	}, apttransports3go.NewConfig(), header)
+
+	assert.NoError(err)
+	assert.Contains(buf.String(), "201 URI Done\n")
+}
+
+func TestFetch_ChecksumOnResume(t *testing.T) {
+	assert := assert.New(t)
+	lastModified := timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00")
+	dl := filepath.Join(t.TempDir(), "key")
+	interruptFetch(t, dl, "apt ", 8, `"etag"`, lastModified)
+	header := map[string][]string{
+		"URI":      {"s3://example.com/key"},
+		"Filename": {dl},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	api := &MockS3API{
+		Content:        "APT body",
+		ContentLength:  8,
+		LastModified:   lastModified,
+		ETag:           `"etag"`,
+		ChecksumSHA256: otherSHA256,
+	}
+	err := apttransports3go.Fetch(ctx, &buf, api, apttransports3go.NewConfig(), header)
+
+	assert.NoError(err)
+	assert.Equal("bytes=4-", *api.GetObjectInput.Range)
+	assert.Equal(types.ChecksumModeEnabled, api.HeadObjectInput.ChecksumMode)
+	assert.Contains(buf.String(), "FailReason: HashSumMismatch\nMessage: checksum mismatch: ChecksumSHA256 expected ")
+	assert.NoFileExists(dl)
+	assert.NoFileExists(dl + ".s3partial")
+}
+
+func TestFetch_CacheChecksumMismatch(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	ctx := log.Logger.WithContext(context.Background())
+	cfg := apttransports3go.NewConfig()
+	cfg.Global.CacheDir = filepath.Join(dir, "cache")
+	err := apttransports3go.Fetch(ctx, io.Discard, newCacheMock("apt body"), cfg, map[string][]string{
+		"URI":      {"s3://example.com/key"},
+		"Filename": {filepath.Join(dir, "first")},
+	})
+	assert.NoError(err)
+
+	// the object is the same, but the cache does not match its checksum
+	entries, _ := filepath.Glob(filepath.Join(cfg.Global.CacheDir, "*", "*"))
+	assert.Len(entries, 1)
+	os.WriteFile(entries[0], []byte("apt babe"), 0644) //nolint:errcheck
+
+	dl := filepath.Join(dir, "second")
+	var buf strings.Builder
+	api := newCacheMock("apt body")
+	api.ChecksumSHA256 = aptBodySHA256
+	err = apttransports3go.Fetch(ctx, &buf, api, cfg, map[string][]string{
+		"URI":      {"s3://example.com/key"},
+		"Filename": {dl},
+	})
+
+	assert.NoError(err)
+	assert.Contains(buf.String(), "201 URI Done\n")
+	assert.NotNil(api.GetObjectInput)
+	content, _ := os.ReadFile(dl)
+	assert.Equal("apt body", string(content))
+}
+
+func TestDownload_Checksum(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	api := &MockS3API{
+		Body:           io.NopCloser(strings.NewReader("apt body")),
+		ContentLength:  8,
+		ChecksumCRC32C: aptBodyCRC32C,
+	}
+	err := apttransports3go.Download(ctx, &buf, api, apttransports3go.NewConfig(), "s3://my-bucket/key")
+
+	assert.NoError(err)
+	assert.Equal("apt body", buf.String())
+	assert.Equal(types.ChecksumModeEnabled, api.GetObjectInput.ChecksumMode)
+}
+
+func TestDownload_ChecksumMismatch(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	err := apttransports3go.Download(ctx, &buf, &MockS3API{
+		Body:           io.NopCloser(strings.NewReader("apt body")),
+		ContentLength:  8,
+		ChecksumSHA256: otherSHA256,
+	}, apttransports3go.NewConfig(), "s3://my-bucket/key")
+
+	assert.ErrorContains(err, "checksum mismatch: ChecksumSHA256 expected 629a1bc5dc2157614c8ac30a990e86e11b6bf1dc36799c8f5676fa4f1e3acbd9")
+	assert.ErrorContains(err, "s3://my-bucket/key")
+}
+
+func TestObjectChecksum_CRC64NVME(t *testing.T) {
+	assert := assert.New(t)
+
+	// the check value of CRC-64/NVME
+	oc := apttransports3go.NewObjectChecksum(types.ChecksumTypeFullObject, map[string]*string{
+		"CRC64NVME": aws.String("rosUhgp5mIg="),
+	}, nil)
+	io.WriteString(oc, "123456789") //nolint:errcheck
+	assert.NoError(apttransports3go.ObjectChecksumVerify(oc))
+}
+
+func TestObjectChecksum_None(t *testing.T) {
+	assert := assert.New(t)
+	oc := apttransports3go.NewObjectChecksum("", map[string]*string{}, nil)
+	assert.Nil(oc)
+}
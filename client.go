@@ -0,0 +1,298 @@
+package apttransports3go
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/rs/zerolog"
+)
+
+// region used to find the bucket's region when no region is configured
+const defaultRegion = "us-east-1"
+
+type S3API interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+}
+
+type clientKey struct {
+	bc     *BucketConfig
+	region string
+	// set only when the bucket has its own credentials
+	bucket string
+}
+
+type Client struct {
+	cfg     *Config
+	mu      sync.Mutex
+	awsCfgs map[*BucketConfig]aws.Config
+	clients map[clientKey]*s3.Client
+	// bucket regions discovered from S3's responses
+	regions map[string]string
+	// credentials from auth.conf or apt by bucket
+	authConf     map[string]aws.Credentials
+	authConfOnce sync.Once
+	providers    map[string]*bucketCredentials
+	authorize    func(ctx context.Context, bucket string) (aws.CredentialsProvider, error)
+}
+
+// bucketCredentials is resolved per bucket without holding Client.mu,
+// because it may wait for IMDS or for apt to reply to "402 Authorization Required".
+// Only a successful resolution is kept; a failed one is tried again by the next fetch.
+type bucketCredentials struct {
+	// one resolution at a time
+	sem      chan struct{}
+	resolved bool
+	provider aws.CredentialsProvider
+}
+
+func NewClient(cfg *Config) *Client {
+	return &Client{
+		cfg:       cfg,
+		awsCfgs:   map[*BucketConfig]aws.Config{},
+		clients:   map[clientKey]*s3.Client{},
+		regions:   map[string]string{},
+		providers: map[string]*bucketCredentials{},
+	}
+}
+
+// credentials returns the credentials provider only for the bucket, or nil to use awsCfg's credentials.
+func (c *Client) credentials(ctx context.Context, awsCfg aws.Config, bucket string) (aws.CredentialsProvider, error) {
+	c.mu.Lock()
+	bcreds, ok := c.providers[bucket]
+
+	if !ok {
+		bcreds = &bucketCredentials{sem: make(chan struct{}, 1)}
+		c.providers[bucket] = bcreds
+	}
+
+	c.mu.Unlock()
+
+	select {
+	case bcreds.sem <- struct{}{}:
+		defer func() { <-bcreds.sem }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if bcreds.resolved {
+		return bcreds.provider, nil
+	}
+
+	provider, err := c.resolveCredentials(ctx, awsCfg, bucket)
+
+	if err != nil {
+		return nil, err
+	}
+
+	bcreds.resolved = true
+	bcreds.provider = provider
+	return provider, nil
+}
+
+func (c *Client) resolveCredentials(ctx context.Context, awsCfg aws.Config, bucket string) (aws.CredentialsProvider, error) {
+	c.authConfOnce.Do(func() {
+		c.authConf = readAuthConf(ctx, c.cfg.AuthConf, c.cfg.AuthConfParts)
+	})
+
+	var provider aws.CredentialsProvider
+
+	if creds, ok := c.authConf[bucket]; ok {
+		zerolog.Ctx(ctx).Debug().Str("bucket", bucket).Msg("use credentials in auth.conf")
+		provider = credentials.NewStaticCredentialsProvider(creds.AccessKeyID, creds.SecretAccessKey, "")
+	} else if _, err := awsCfg.Credentials.Retrieve(ctx); err != nil && c.authorize != nil {
+		// the fetch has been canceled rather than the credentials are missing
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		zerolog.Ctx(ctx).Debug().Err(err).Str("bucket", bucket).Msg("no credentials resolved")
+		provider, err = c.authorize(ctx, bucket)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return provider, nil
+}
+
+func (c *Client) awsConfig(ctx context.Context, bc *BucketConfig) (aws.Config, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if awsCfg, ok := c.awsCfgs[bc]; ok {
+		return awsCfg, nil
+	}
+
+	awsCfg, err := c.cfg.loadAWSConfig(ctx, bc)
+
+	if err != nil {
+		return aws.Config{}, err
+	}
+
+	c.awsCfgs[bc] = awsCfg
+	return awsCfg, nil
+}
+
+func (c *Client) client(ctx context.Context, bucket string) (*s3.Client, error) {
+	bc := c.cfg.Bucket(bucket)
+	awsCfg, err := c.awsConfig(ctx, bc)
+
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := c.credentials(ctx, awsCfg, bucket)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	region := awsCfg.Region
+
+	if r, ok := c.regions[bucket]; ok {
+		region = r
+	}
+
+	// buckets without their own scope and credentials share the global client
+	key := clientKey{bc: bc, region: region}
+
+	if provider != nil {
+		key.bucket = bucket
+	}
+
+	if client, ok := c.clients[key]; ok {
+		return client, nil
+	}
+
+	zerolog.Ctx(ctx).Debug().Str("bucket", bucket).Str("region", region).Msg("create s3 client")
+	client := s3.NewFromConfig(awsCfg, bc.s3Options, func(o *s3.Options) {
+		o.Region = region
+
+		if provider != nil {
+			o.Credentials = provider
+		}
+	})
+
+	c.clients[key] = client
+	return client, nil
+}
+
+// relocate records the bucket's region if err shows that the request was sent to a wrong region.
+func (c *Client) relocate(ctx context.Context, client *s3.Client, bucket string, err error) bool {
+	var respErr *smithyhttp.ResponseError
+
+	if !errors.As(err, &respErr) {
+		return false
+	}
+
+	region := respErr.Response.Header.Get("X-Amz-Bucket-Region")
+
+	if region == "" && respErr.HTTPStatusCode() == http.StatusMovedPermanently {
+		out, err := client.HeadBucket(ctx, &s3.HeadBucketInput{
+			Bucket:              c.accessPoint(bucket),
+			ExpectedBucketOwner: c.cfg.Bucket(bucket).expectedBucketOwner(),
+		})
+
+		if err == nil {
+			region = aws.ToString(out.BucketRegion)
+		} else if errors.As(err, &respErr) {
+			region = respErr.Response.Header.Get("X-Amz-Bucket-Region")
+		}
+	}
+
+	if region == "" || region == client.Options().Region {
+		return false
+	}
+
+	zerolog.Ctx(ctx).Debug().Str("bucket", bucket).Str("region", region).Msg("discover bucket region")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.regions[bucket] = region
+	return true
+}
+
+func (c *Client) do(ctx context.Context, bucket string, call func(*s3.Client) error) error {
+	client, err := c.client(ctx, bucket)
+
+	if err != nil {
+		return err
+	}
+
+	err = call(client)
+
+	if !c.relocate(ctx, client, bucket, err) {
+		return err
+	}
+
+	client, err = c.client(ctx, bucket)
+
+	if err != nil {
+		return err
+	}
+
+	return call(client)
+}
+
+// accessPoint returns the ARN for the bucket if it is an alias of an access point.
+func (c *Client) accessPoint(bucket string) *string {
+	if ap := c.cfg.Bucket(bucket).AccessPoint; ap != "" {
+		return aws.String(ap)
+	}
+
+	return aws.String(bucket)
+}
+
+func (c *Client) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	var out *s3.GetObjectOutput
+	bucket := aws.ToString(params.Bucket)
+	input := *params
+	input.Bucket = c.accessPoint(bucket)
+
+	err := c.do(ctx, bucket, func(client *s3.Client) error {
+		var err error
+		out, err = client.GetObject(ctx, &input, optFns...)
+		return err
+	})
+
+	return out, err
+}
+
+func (c *Client) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	var out *s3.HeadObjectOutput
+	bucket := aws.ToString(params.Bucket)
+	input := *params
+	input.Bucket = c.accessPoint(bucket)
+
+	err := c.do(ctx, bucket, func(client *s3.Client) error {
+		var err error
+		out, err = client.HeadObject(ctx, &input, optFns...)
+		return err
+	})
+
+	return out, err
+}
+
+func (c *Client) HeadBucket(ctx context.Context, params *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+	var out *s3.HeadBucketOutput
+	bucket := aws.ToString(params.Bucket)
+	input := *params
+	input.Bucket = c.accessPoint(bucket)
+
+	err := c.do(ctx, bucket, func(client *s3.Client) error {
+		var err error
+		out, err = client.HeadBucket(ctx, &input, optFns...)
+		return err
+	})
+
+	return out, err
+}
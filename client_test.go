@@ -0,0 +1,356 @@
+package apttransports3go_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	apttransports3go "github.com/winebarrel/apt-transport-s3-go"
+)
+
+func TestClient_Bucket(t *testing.T) {
+	assert := assert.New(t)
+	setAWSEnv(t)
+	header := map[string][]string{
+		"Config-Item": {
+			"Acquire::s3::region=ap-northeast-1",
+			"Acquire::s3::region::my-bucket=us-west-2",
+		},
+	}
+
+	ctx := log.Logger.WithContext(context.Background())
+	cfg, _ := apttransports3go.Configure(ctx, header)
+	client := apttransports3go.NewClient(cfg)
+
+	foo, err := apttransports3go.ClientClient(client, ctx, "foo")
+	assert.NoError(err)
+	assert.Equal("ap-northeast-1", foo.Options().Region)
+
+	bar, err := apttransports3go.ClientClient(client, ctx, "bar")
+	assert.NoError(err)
+	assert.Same(foo, bar)
+
+	myBucket, err := apttransports3go.ClientClient(client, ctx, "my-bucket")
+	assert.NoError(err)
+	assert.Equal("us-west-2", myBucket.Options().Region)
+	assert.NotSame(foo, myBucket)
+}
+
+func TestClient_BadProfile(t *testing.T) {
+	assert := assert.New(t)
+	setAWSEnv(t)
+	header := map[string][]string{
+		"Config-Item": {"Acquire::s3::Profile::my-bucket=no-such-profile"},
+	}
+
+	ctx := log.Logger.WithContext(context.Background())
+	cfg, _ := apttransports3go.Configure(ctx, header)
+	client := apttransports3go.NewClient(cfg)
+
+	_, err := apttransports3go.ClientClient(client, ctx, "my-bucket")
+	assert.ErrorContains(err, "no-such-profile")
+}
+
+func TestClient_DiscoverRegion(t *testing.T) {
+	assert := assert.New(t)
+	var requests atomic.Int32
+	ts := newS3Server(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("X-Amz-Bucket-Region", "ap-northeast-1")
+
+		if signingRegion(r) != "ap-northeast-1" {
+			w.WriteHeader(http.StatusMovedPermanently)
+			return
+		}
+
+		w.Write([]byte("body")) //nolint:errcheck
+	})
+
+	header := map[string][]string{
+		"Config-Item": {
+			"Acquire::s3::Endpoint=" + ts.URL,
+			"Acquire::s3::UsePathStyle=true",
+		},
+	}
+
+	ctx := log.Logger.WithContext(context.Background())
+	cfg, _ := apttransports3go.Configure(ctx, header)
+	client := apttransports3go.NewClient(cfg)
+
+	obj, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("my-bucket"), Key: aws.String("key")})
+	assert.NoError(err)
+	body, _ := io.ReadAll(obj.Body)
+	assert.Equal("body", string(body))
+	assert.Equal(int32(2), requests.Load())
+
+	// the discovered region is cached
+	_, err = client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("my-bucket"), Key: aws.String("key")})
+	assert.NoError(err)
+	assert.Equal(int32(3), requests.Load())
+}
+
+func TestClient_DiscoverRegionByHeadBucket(t *testing.T) {
+	assert := assert.New(t)
+	ts := newS3Server(t, func(w http.ResponseWriter, r *http.Request) {
+		if signingRegion(r) == "us-west-2" {
+			w.Write([]byte("body")) //nolint:errcheck
+		} else if r.Method == http.MethodHead && r.URL.Path == "/my-bucket" {
+			w.Header().Set("X-Amz-Bucket-Region", "us-west-2")
+			w.WriteHeader(http.StatusMovedPermanently)
+		} else {
+			w.WriteHeader(http.StatusMovedPermanently)
+		}
+	})
+
+	header := map[string][]string{
+		"Config-Item": {
+			"Acquire::s3::region=eu-west-1",
+			"Acquire::s3::Endpoint=" + ts.URL,
+			"Acquire::s3::UsePathStyle=true",
+		},
+	}
+
+	ctx := log.Logger.WithContext(context.Background())
+	cfg, _ := apttransports3go.Configure(ctx, header)
+	client := apttransports3go.NewClient(cfg)
+
+	_, err := client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("my-bucket"), Key: aws.String("key")})
+	assert.NoError(err)
+	c, _ := apttransports3go.ClientClient(client, ctx, "my-bucket")
+	assert.Equal("us-west-2", c.Options().Region)
+	c, _ = apttransports3go.ClientClient(client, ctx, "other-bucket")
+	assert.Equal("eu-west-1", c.Options().Region)
+}
+
+func TestClient_AssumeRole(t *testing.T) {
+	assert := assert.New(t)
+	var assumeRoles atomic.Int32
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assumeRoles.Add(1)
+		r.ParseForm() //nolint:errcheck
+		assert.Equal("AssumeRole", r.Form.Get("Action"))
+		assert.Equal("arn:aws:iam::123456789012:role/apt", r.Form.Get("RoleArn"))
+		assert.Equal("my-external-id", r.Form.Get("ExternalId"))
+		assert.Equal("apt-transport-s3-go", r.Form.Get("RoleSessionName"))
+		w.Write([]byte(`<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ASIAASSUMEDROLE</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>token</SessionToken>
+      <Expiration>2099-01-01T00:00:00Z</Expiration>
+    </Credentials>
+    <AssumedRoleUser>
+      <Arn>arn:aws:sts::123456789012:assumed-role/apt/apt-transport-s3-go</Arn>
+      <AssumedRoleId>AROAEXAMPLE:apt-transport-s3-go</AssumedRoleId>
+    </AssumedRoleUser>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`)) //nolint:errcheck
+	}))
+	defer sts.Close()
+
+	ts := newS3Server(t, func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Authorization"), "Credential=ASIAASSUMEDROLE/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		w.Write([]byte("body")) //nolint:errcheck
+	})
+	t.Setenv("AWS_ENDPOINT_URL_STS", sts.URL)
+
+	header := map[string][]string{
+		"Config-Item": {
+			"Acquire::s3::region=ap-northeast-1",
+			"Acquire::s3::Endpoint=" + ts.URL,
+			"Acquire::s3::UsePathStyle=true",
+			"Acquire::s3::RoleArn::my-bucket=arn:aws:iam::123456789012:role/apt",
+			"Acquire::s3::ExternalId::my-bucket=my-external-id",
+		},
+	}
+
+	ctx := log.Logger.WithContext(context.Background())
+	cfg, _ := apttransports3go.Configure(ctx, header)
+	client := apttransports3go.NewClient(cfg)
+
+	for range 2 {
+		_, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("my-bucket"), Key: aws.String("key")})
+		assert.NoError(err)
+	}
+
+	// the assumed role's credentials are cached
+	assert.Equal(int32(1), assumeRoles.Load())
+
+	_, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("other-bucket"), Key: aws.String("key")})
+	assert.ErrorContains(err, "StatusCode: 403")
+}
+
+// stsHTTPClient answers AssumeRole and then S3 requests without network access.
+type stsHTTPClient struct {
+	recordingHTTPClient
+}
+
+func (c *stsHTTPClient) Do(r *http.Request) (*http.Response, error) {
+	if !strings.HasPrefix(r.URL.Host, "sts.") {
+		return c.recordingHTTPClient.Do(r)
+	}
+
+	c.mu.Lock()
+	c.reqs = append(c.reqs, r)
+	c.mu.Unlock()
+	body := `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ASIAASSUMEDROLE</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>token</SessionToken>
+      <Expiration>2099-01-01T00:00:00Z</Expiration>
+    </Credentials>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"text/xml"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    r,
+	}, nil
+}
+
+func TestClient_AssumeRoleWithoutRegion(t *testing.T) {
+	assert := assert.New(t)
+	setAWSEnv(t)
+	httpClient := &stsHTTPClient{recordingHTTPClient{body: "body"}}
+	header := map[string][]string{
+		"Config-Item": {
+			"Acquire::s3::RoleArn=arn:aws:iam::123456789012:role/apt",
+		},
+	}
+
+	ctx := log.Logger.WithContext(context.Background())
+	cfg, _ := apttransports3go.Configure(ctx, header)
+	cfg.HTTPClient = httpClient
+	client := apttransports3go.NewClient(cfg)
+
+	_, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("my-bucket"), Key: aws.String("key")})
+	assert.NoError(err)
+	assert.Len(httpClient.reqs, 2)
+	assert.Equal("sts.us-east-1.amazonaws.com", httpClient.reqs[0].URL.Host)
+	assert.Contains(httpClient.reqs[1].Header.Get("Authorization"), "Credential=ASIAASSUMEDROLE/")
+}
+
+func TestClient_AuthConf(t *testing.T) {
+	assert := assert.New(t)
+	ts := newS3Server(t, func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Authorization"), "Credential=AKIAAUTHCONF/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		w.Write([]byte("body")) //nolint:errcheck
+	})
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "auth.conf"), []byte("machine my-bucket login AKIAAUTHCONF password secret"), 0600) //nolint:errcheck
+
+	header := map[string][]string{
+		"Config-Item": {
+			"Dir::Etc=" + dir,
+			"Acquire::s3::region=ap-northeast-1",
+			"Acquire::s3::Endpoint=" + ts.URL,
+			"Acquire::s3::UsePathStyle=true",
+		},
+	}
+
+	ctx := log.Logger.WithContext(context.Background())
+	cfg, _ := apttransports3go.Configure(ctx, header)
+	client := apttransports3go.NewClient(cfg)
+
+	_, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("my-bucket"), Key: aws.String("key")})
+	assert.NoError(err)
+
+	// other buckets use the default credentials
+	_, err = client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("other-bucket"), Key: aws.String("key")})
+	assert.ErrorContains(err, "StatusCode: 403")
+}
+
+func TestClient_AccessPoint(t *testing.T) {
+	assert := assert.New(t)
+	setAWSEnv(t)
+	httpClient := &recordingHTTPClient{body: "apt body"}
+	cfg := apttransports3go.NewConfig()
+	cfg.HTTPClient = httpClient
+	client := apttransports3go.NewClient(cfg)
+	ctx := log.Logger.WithContext(context.Background())
+
+	_, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String("arn:aws:s3:us-west-2:123456789012:accesspoint/my-ap"),
+		Key:    aws.String("key"),
+	})
+
+	assert.NoError(err)
+	req := httpClient.reqs[0]
+	assert.Equal("my-ap-123456789012.s3-accesspoint.us-west-2.amazonaws.com", req.URL.Host)
+	assert.Equal("/key", req.URL.Path)
+	assert.Contains(req.Header.Get("Authorization"), "/us-west-2/s3/aws4_request")
+}
+
+func TestClient_MultiRegionAccessPoint(t *testing.T) {
+	assert := assert.New(t)
+	setAWSEnv(t)
+	httpClient := &recordingHTTPClient{body: "apt body"}
+	cfg := apttransports3go.NewConfig()
+	cfg.HTTPClient = httpClient
+	client := apttransports3go.NewClient(cfg)
+	ctx := log.Logger.WithContext(context.Background())
+
+	_, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String("arn:aws:s3::123456789012:accesspoint/mfzwi23gnjvgw.mrap"),
+		Key:    aws.String("key"),
+	})
+
+	assert.NoError(err)
+	req := httpClient.reqs[0]
+	assert.Equal("mfzwi23gnjvgw.mrap.accesspoint.s3-global.amazonaws.com", req.URL.Host)
+	// MRAPs are signed with SigV4A
+	assert.True(strings.HasPrefix(req.Header.Get("Authorization"), "AWS4-ECDSA-P256-SHA256 "))
+	assert.Equal("*", req.Header.Get("X-Amz-Region-Set"))
+}
+
+func TestClient_AccessPointAlias(t *testing.T) {
+	assert := assert.New(t)
+	setAWSEnv(t)
+	httpClient := &recordingHTTPClient{body: "apt body"}
+	header := map[string][]string{
+		"Config-Item": {
+			"Acquire::s3::AccessPoint::my-alias=arn:aws:s3:ap-northeast-1:123456789012:accesspoint/my-ap",
+		},
+	}
+
+	ctx := log.Logger.WithContext(context.Background())
+	cfg, err := apttransports3go.Configure(ctx, header)
+	assert.NoError(err)
+	cfg.HTTPClient = httpClient
+	client := apttransports3go.NewClient(cfg)
+	params := &s3.GetObjectInput{
+		Bucket: aws.String("my-alias"),
+		Key:    aws.String("dists/stable/Release"),
+	}
+	_, err = client.GetObject(ctx, params)
+
+	assert.NoError(err)
+	req := httpClient.reqs[0]
+	assert.Equal("my-ap-123456789012.s3-accesspoint.ap-northeast-1.amazonaws.com", req.URL.Host)
+	assert.Equal("/dists/stable/Release", req.URL.Path)
+	assert.Equal("my-alias", *params.Bucket)
+}
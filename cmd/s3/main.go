@@ -2,32 +2,62 @@ package main
 
 import (
 	"context"
+	"flag"
 	"os"
 	"strings"
 
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	apttransports3go "github.com/winebarrel/apt-transport-s3-go"
 )
 
+type configItems []string
+
+func (items *configItems) String() string {
+	return strings.Join(*items, ",")
+}
+
+func (items *configItems) Set(value string) error {
+	*items = append(*items, value)
+	return nil
+}
+
 func main() {
-	logger := zerolog.New(os.Stderr).With().Timestamp().Int("pid", os.Getpid()).Logger()
+	logLevel := zerolog.InfoLevel
+	var logLevelErr error
+
+	if logLevelStr := os.Getenv("ATS3_LOG_LEVEL"); logLevelStr != "" {
+		if level, err := zerolog.ParseLevel(logLevelStr); err != nil {
+			logLevelErr = err
+		} else {
+			logLevel = level
+		}
+	}
+
+	logger := zerolog.New(os.Stderr).Level(logLevel).With().Timestamp().Int("pid", os.Getpid()).Logger()
+
+	if logLevelErr != nil {
+		logger.Warn().Err(logLevelErr).Msg("bad ATS3_LOG_LEVEL")
+	}
+
 	ctx := logger.WithContext(context.Background())
 	logger.Debug().Msg("start apt-transport-s3-go")
 
-	if len(os.Args) == 2 && strings.HasPrefix(os.Args[1], "s3://") {
-		uri := os.Args[1]
-		cfg, err := config.LoadDefaultConfig(ctx)
+	if len(os.Args) >= 2 && strings.HasPrefix(os.Args[len(os.Args)-1], "s3://") {
+		flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+		var items configItems
+		flags.Var(&items, "o", "set a configuration option like apt (e.g. -o Acquire::s3::region=ap-northeast-1)")
+		flags.Parse(os.Args[1:]) //nolint:errcheck
+		uri := flags.Arg(0)
+		cfg, err := apttransports3go.Configure(ctx, map[string][]string{"Config-Item": items})
 
 		if err != nil {
 			log.Fatal().Err(err).Send()
 		}
 
-		client := s3.NewFromConfig(cfg)
+		client := apttransports3go.NewClient(cfg)
 
-		if err := apttransports3go.Download(ctx, os.Stdout, client, uri); err != nil {
+		if err := apttransports3go.Download(ctx, os.Stdout, client, cfg, uri); err != nil {
 			log.Fatal().Err(err).Send()
 		}
 	} else {
@@ -0,0 +1,469 @@
+package apttransports3go
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/rs/zerolog"
+)
+
+const (
+	defaultMaxParallel     = 4
+	defaultRetryDelay      = 1 * time.Second
+	defaultMaxRetryDelay   = 30 * time.Second
+	defaultRoleSessionName = "apt-transport-s3-go"
+	defaultPartSize        = 16 * 1024 * 1024
+	defaultConcurrency     = 4
+	minPartSize            = 1024 * 1024
+)
+
+var accountIDPattern = regexp.MustCompile(`^\d{12}$`)
+
+type BucketConfig struct {
+	Region          string
+	Endpoint        string
+	UsePathStyle    bool
+	DisableTLS      bool
+	Profile         string
+	RoleArn         string
+	ExternalID      string
+	RoleSessionName string
+	PartSize        int64
+	Concurrency     int
+	CacheDir        string
+	AccessPoint     string
+	RequesterPays   bool
+	SSECustomerKey  secret
+	// account ID that must own the bucket
+	ExpectedBucketOwner string
+}
+
+// set applies "Acquire::s3::<name>" to the bucket configuration.
+func (bc *BucketConfig) set(name string, value string) error {
+	switch name {
+	case "region":
+		bc.Region = value
+	case "endpoint":
+		bc.Endpoint = value
+	case "usepathstyle":
+		b, err := parseBool(value)
+
+		if err != nil {
+			return fmt.Errorf("bad UsePathStyle: %w", err)
+		}
+
+		bc.UsePathStyle = b
+	case "disabletls":
+		b, err := parseBool(value)
+
+		if err != nil {
+			return fmt.Errorf("bad DisableTLS: %w", err)
+		}
+
+		bc.DisableTLS = b
+	case "profile":
+		bc.Profile = value
+	case "rolearn":
+		bc.RoleArn = value
+	case "externalid":
+		bc.ExternalID = value
+	case "rolesessionname":
+		bc.RoleSessionName = value
+	case "partsize":
+		n, err := parseSize(value)
+
+		if err != nil || n < minPartSize {
+			return fmt.Errorf("bad PartSize: %s", value)
+		}
+
+		bc.PartSize = n
+	case "concurrency":
+		n, err := strconv.Atoi(value)
+
+		if err != nil || n < 1 {
+			return fmt.Errorf("bad Concurrency: %s", value)
+		}
+
+		bc.Concurrency = n
+	case "cachedir":
+		bc.CacheDir = value
+	case "requesterpays":
+		b, err := parseBool(value)
+
+		if err != nil {
+			return fmt.Errorf("bad RequesterPays: %w", err)
+		}
+
+		bc.RequesterPays = b
+	case "expectedbucketowner":
+		if !accountIDPattern.MatchString(value) {
+			return fmt.Errorf("bad ExpectedBucketOwner: %s", value)
+		}
+
+		bc.ExpectedBucketOwner = value
+	case "ssecustomerkeyfile":
+		key, err := readSSECustomerKey(value)
+
+		if err != nil {
+			return fmt.Errorf("bad SSECustomerKeyFile: %w", err)
+		}
+
+		bc.SSECustomerKey = key
+	case "accesspoint":
+		if !arn.IsARN(value) {
+			return fmt.Errorf("bad AccessPoint: %s", value)
+		}
+
+		bc.AccessPoint = value
+	}
+
+	return nil
+}
+
+func (bc *BucketConfig) requestPayer() types.RequestPayer {
+	if bc.RequesterPays {
+		return types.RequestPayerRequester
+	}
+
+	return ""
+}
+
+func (bc *BucketConfig) expectedBucketOwner() *string {
+	if bc.ExpectedBucketOwner == "" {
+		return nil
+	}
+
+	return aws.String(bc.ExpectedBucketOwner)
+}
+
+// applyRequestOptions sets the bucket's options sent with every GetObject and HeadObject.
+// Both input types are handled here so that a new option is not missed in either of them.
+func applyRequestOptions[T *s3.GetObjectInput | *s3.HeadObjectInput](bc *BucketConfig, input T) {
+	payer := bc.requestPayer()
+	owner := bc.expectedBucketOwner()
+	alg, key, keyMD5 := bc.sseCustomerKey()
+
+	switch in := any(input).(type) {
+	case *s3.GetObjectInput:
+		in.RequestPayer, in.ExpectedBucketOwner, in.ChecksumMode = payer, owner, types.ChecksumModeEnabled
+		in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = alg, key, keyMD5
+	case *s3.HeadObjectInput:
+		in.RequestPayer, in.ExpectedBucketOwner, in.ChecksumMode = payer, owner, types.ChecksumModeEnabled
+		in.SSECustomerAlgorithm, in.SSECustomerKey, in.SSECustomerKeyMD5 = alg, key, keyMD5
+	}
+}
+
+func (bc *BucketConfig) s3Options(o *s3.Options) {
+	o.UsePathStyle = bc.UsePathStyle
+	// access point ARNs are sent to their own region
+	o.UseARNRegion = true
+	o.EndpointOptions.DisableHTTPS = bc.DisableTLS
+
+	if bc.Endpoint != "" {
+		endpoint := bc.Endpoint
+
+		if !strings.Contains(endpoint, "://") {
+			endpoint = "https://" + endpoint
+		}
+
+		if bc.DisableTLS {
+			endpoint = "http://" + strings.TrimPrefix(endpoint, "https://")
+		}
+
+		o.BaseEndpoint = aws.String(endpoint)
+	}
+}
+
+type Config struct {
+	MaxParallel   int
+	Retries       int
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+	HTTPClient    aws.HTTPClient
+	Debug         bool
+	AuthConf      string
+	AuthConfParts string
+	// "<bucket glob>[/<key prefix>]" patterns
+	AllowedBuckets []string
+	DeniedBuckets  []string
+	Global         BucketConfig
+	// "Acquire::s3::<name>::<bucket>" items merged over Global
+	Buckets map[string]*BucketConfig
+}
+
+func NewConfig() *Config {
+	return &Config{
+		MaxParallel:   defaultMaxParallel,
+		RetryDelay:    defaultRetryDelay,
+		MaxRetryDelay: defaultMaxRetryDelay,
+		AuthConf:      "/etc/apt/auth.conf",
+		AuthConfParts: "/etc/apt/auth.conf.d",
+		Global: BucketConfig{
+			PartSize:    defaultPartSize,
+			Concurrency: defaultConcurrency,
+		},
+		Buckets: map[string]*BucketConfig{},
+	}
+}
+
+func (cfg *Config) Bucket(bucket string) *BucketConfig {
+	if bc, ok := cfg.Buckets[bucket]; ok {
+		return bc
+	}
+
+	return &cfg.Global
+}
+
+func (cfg *Config) loadAWSConfig(ctx context.Context, bc *BucketConfig) (aws.Config, error) {
+	optFuns := []func(*config.LoadOptions) error{}
+
+	if cfg.HTTPClient != nil {
+		optFuns = append(optFuns, config.WithHTTPClient(cfg.HTTPClient))
+	}
+
+	if bc.Region != "" {
+		optFuns = append(optFuns, config.WithRegion(bc.Region))
+	}
+
+	if bc.Profile != "" {
+		optFuns = append(optFuns, config.WithSharedConfigProfile(bc.Profile))
+	}
+
+	awsCfg, err := config.LoadDefaultConfig(ctx, optFuns...)
+
+	if err != nil {
+		return aws.Config{}, err
+	}
+
+	// STS also needs a region to assume the role
+	if awsCfg.Region == "" {
+		awsCfg.Region = defaultRegion
+	}
+
+	if bc.RoleArn != "" {
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(awsCfg), bc.RoleArn, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = defaultRoleSessionName
+
+			if bc.RoleSessionName != "" {
+				o.RoleSessionName = bc.RoleSessionName
+			}
+
+			if bc.ExternalID != "" {
+				o.ExternalID = aws.String(bc.ExternalID)
+			}
+		})
+
+		// the assumed role's credentials are refreshed before they expire
+		awsCfg.Credentials = aws.NewCredentialsCache(provider)
+	}
+
+	return awsCfg, nil
+}
+
+// retryDelay returns the exponential backoff delay with full jitter.
+func (cfg *Config) retryDelay(attempt int) time.Duration {
+	if cfg.RetryDelay <= 0 || cfg.MaxRetryDelay <= 0 {
+		return 0
+	}
+
+	delay := cfg.RetryDelay << attempt
+
+	// also handle overflow
+	if delay <= 0 || delay > cfg.MaxRetryDelay {
+		delay = cfg.MaxRetryDelay
+	}
+
+	return rand.N(delay)
+}
+
+// findPath resolves a path relative to its parent directory in the same way as apt's FindFile.
+func findPath(parent string, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(parent, path)
+}
+
+// splitS3Key splits "Acquire::s3::<name>[::<bucket>]" into the lower-cased name and the bucket.
+func splitS3Key(key string) (string, string, bool) {
+	words := strings.SplitN(key, "::", 4)
+
+	if len(words) < 3 || !strings.EqualFold(words[0], "Acquire") || !strings.EqualFold(words[1], "s3") {
+		return "", "", false
+	}
+
+	name := strings.ToLower(words[2])
+	bucket := ""
+
+	if len(words) == 4 {
+		bucket = words[3]
+	}
+
+	return name, bucket, true
+}
+
+func Configure(ctx context.Context, header map[string][]string) (*Config, error) {
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Msg("start configure")
+	defer logger.Debug().Msg("finish configure")
+	cfg := NewConfig()
+	// per-bucket items are applied after all global items regardless of their order
+	bucketItems := map[string][][2]string{}
+	buckets := []string{}
+	// the same defaults as apt
+	dirs := map[string]string{
+		"dir":                  "/",
+		"dir::etc":             "etc/apt/",
+		"dir::etc::netrc":      "auth.conf",
+		"dir::etc::netrcparts": "auth.conf.d",
+	}
+
+	for _, item := range header["Config-Item"] {
+		words := strings.SplitN(item, "=", 2)
+
+		if len(words) < 2 {
+			return nil, fmt.Errorf("bad config item: %s", item)
+		}
+
+		key := words[0]
+		value := words[1]
+
+		if key == "Acquire::http::Proxy" {
+			proxyURL, err := url.Parse(value)
+
+			if err != nil {
+				return nil, fmt.Errorf("bad proxy URL: %w: %s", err, value)
+			}
+
+			cfg.HTTPClient = awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+				tr.Proxy = http.ProxyURL(proxyURL)
+			})
+
+			logger.Debug().Str(key, value).Msg("configure")
+			continue
+		}
+
+		switch strings.ToLower(key) {
+		case "acquire::retries":
+			n, err := strconv.Atoi(value)
+
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("bad Retries: %s", value)
+			}
+
+			cfg.Retries = n
+			logger.Debug().Str(key, value).Msg("configure")
+			continue
+		case "acquire::retries::delay::maximum":
+			n, err := strconv.Atoi(value)
+
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("bad Retries::Delay::Maximum: %s", value)
+			}
+
+			cfg.MaxRetryDelay = time.Duration(n) * time.Second
+			logger.Debug().Str(key, value).Msg("configure")
+			continue
+		case "debug::acquire::s3":
+			b, err := parseBool(value)
+
+			if err != nil {
+				return nil, fmt.Errorf("bad Debug::Acquire::s3: %w", err)
+			}
+
+			cfg.Debug = b
+			logger.Debug().Str(key, value).Msg("configure")
+			continue
+		}
+
+		if _, ok := dirs[strings.ToLower(key)]; ok {
+			dirs[strings.ToLower(key)] = value
+			logger.Debug().Str(key, value).Msg("configure")
+			continue
+		}
+
+		name, bucket, ok := splitS3Key(key)
+
+		if !ok {
+			continue
+		}
+
+		// list items come as "Acquire::s3::AllowedBuckets::=<pattern>"
+		if (name == "allowedbuckets" || name == "deniedbuckets") && bucket != "" {
+			return nil, fmt.Errorf("bucket policy cannot be scoped to a bucket: %s", key)
+		}
+
+		if bucket != "" {
+			if _, ok := bucketItems[bucket]; !ok {
+				buckets = append(buckets, bucket)
+			}
+
+			bucketItems[bucket] = append(bucketItems[bucket], [2]string{name, value})
+		} else if name == "accesspoint" {
+			return nil, fmt.Errorf("AccessPoint must be scoped to an alias: Acquire::s3::AccessPoint::<alias>")
+		} else if name == "allowedbuckets" {
+			patterns, err := parseBucketPatterns(value)
+
+			if err != nil {
+				return nil, fmt.Errorf("bad AllowedBuckets: %w", err)
+			}
+
+			cfg.AllowedBuckets = append(cfg.AllowedBuckets, patterns...)
+		} else if name == "deniedbuckets" {
+			patterns, err := parseBucketPatterns(value)
+
+			if err != nil {
+				return nil, fmt.Errorf("bad DeniedBuckets: %w", err)
+			}
+
+			cfg.DeniedBuckets = append(cfg.DeniedBuckets, patterns...)
+		} else if name == "max-parallel" {
+			n, err := strconv.Atoi(value)
+
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("bad Max-Parallel: %s", value)
+			}
+
+			cfg.MaxParallel = n
+		} else if err := cfg.Global.set(name, value); err != nil {
+			return nil, err
+		}
+
+		logger.Debug().Str(key, value).Msg("configure")
+	}
+
+	etc := findPath(dirs["dir"], dirs["dir::etc"])
+	cfg.AuthConf = findPath(etc, dirs["dir::etc::netrc"])
+	cfg.AuthConfParts = findPath(etc, dirs["dir::etc::netrcparts"])
+
+	for _, bucket := range buckets {
+		bc := cfg.Global
+
+		for _, nv := range bucketItems[bucket] {
+			if err := bc.set(nv[0], nv[1]); err != nil {
+				return nil, fmt.Errorf("%w: %s", err, bucket)
+			}
+		}
+
+		cfg.Buckets[bucket] = &bc
+	}
+
+	return cfg, nil
+}
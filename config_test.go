@@ -0,0 +1,397 @@
+package apttransports3go_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	apttransports3go "github.com/winebarrel/apt-transport-s3-go"
+)
+
+func TestConfigure_OK(t *testing.T) {
+	assert := assert.New(t)
+	header := map[string][]string{
+		"Config-Item": {"Acquire::http::Proxy=http://example.com"},
+	}
+
+	ctx := log.Logger.WithContext(context.Background())
+	_, err := apttransports3go.Configure(ctx, header)
+	assert.NoError(err)
+}
+
+func TestConfigure_MaxParallel(t *testing.T) {
+	assert := assert.New(t)
+	header := map[string][]string{
+		"Config-Item": {"Acquire::s3::Max-Parallel=16"},
+	}
+
+	ctx := log.Logger.WithContext(context.Background())
+	cfg, err := apttransports3go.Configure(ctx, header)
+	assert.NoError(err)
+	assert.Equal(16, cfg.MaxParallel)
+}
+
+func TestConfigure_BadMaxParallel(t *testing.T) {
+	assert := assert.New(t)
+	header := map[string][]string{
+		"Config-Item": {"Acquire::s3::Max-Parallel=0"},
+	}
+
+	ctx := log.Logger.WithContext(context.Background())
+	_, err := apttransports3go.Configure(ctx, header)
+	assert.EqualError(err, "bad Max-Parallel: 0")
+}
+
+func TestConfigure_Endpoint(t *testing.T) {
+	assert := assert.New(t)
+
+	tt := []struct {
+		items    []string
+		expected string
+		https    bool
+	}{
+		{[]string{"Acquire::s3::Endpoint=http://localhost:9000"}, "http://localhost:9000", true},
+		{[]string{"Acquire::s3::Endpoint=minio.example.com"}, "https://minio.example.com", true},
+		{[]string{"Acquire::s3::Endpoint=minio.example.com", "Acquire::s3::DisableTLS=true"}, "http://minio.example.com", false},
+		{[]string{"Acquire::s3::Endpoint=https://minio.example.com", "Acquire::s3::DisableTLS=yes"}, "http://minio.example.com", false},
+	}
+
+	for _, t := range tt {
+		ctx := log.Logger.WithContext(context.Background())
+		cfg, err := apttransports3go.Configure(ctx, map[string][]string{"Config-Item": t.items})
+		assert.NoError(err)
+		var o s3.Options
+		apttransports3go.BucketConfigS3Options(&cfg.Global, &o)
+		assert.Equal(t.expected, *o.BaseEndpoint)
+		assert.Equal(!t.https, o.EndpointOptions.DisableHTTPS)
+		assert.False(o.UsePathStyle)
+	}
+}
+
+func TestConfigure_UsePathStyle(t *testing.T) {
+	assert := assert.New(t)
+	header := map[string][]string{
+		"Config-Item": {"Acquire::s3::UsePathStyle=true"},
+	}
+
+	ctx := log.Logger.WithContext(context.Background())
+	cfg, err := apttransports3go.Configure(ctx, header)
+	assert.NoError(err)
+	var o s3.Options
+	apttransports3go.BucketConfigS3Options(&cfg.Global, &o)
+	assert.True(o.UsePathStyle)
+	assert.Nil(o.BaseEndpoint)
+}
+
+func TestConfigure_BadUsePathStyle(t *testing.T) {
+	assert := assert.New(t)
+	header := map[string][]string{
+		"Config-Item": {"Acquire::s3::UsePathStyle=maybe"},
+	}
+
+	ctx := log.Logger.WithContext(context.Background())
+	_, err := apttransports3go.Configure(ctx, header)
+	assert.EqualError(err, "bad UsePathStyle: invalid boolean: maybe")
+}
+
+func TestConfigure_Bucket(t *testing.T) {
+	assert := assert.New(t)
+	header := map[string][]string{
+		"Config-Item": {
+			"Acquire::s3::region::my-bucket=us-west-2",
+			"Acquire::s3::Endpoint::minio-bucket=http://localhost:9000",
+			"Acquire::s3::UsePathStyle::minio-bucket=true",
+			"Acquire::s3::Profile::my-bucket=my-profile",
+			"Acquire::s3::region=ap-northeast-1",
+		},
+	}
+
+	ctx := log.Logger.WithContext(context.Background())
+	cfg, err := apttransports3go.Configure(ctx, header)
+	assert.NoError(err)
+
+	assert.Equal(&apttransports3go.BucketConfig{
+		Region:      "ap-northeast-1",
+		PartSize:    16 * 1024 * 1024,
+		Concurrency: 4,
+	}, cfg.Bucket("other-bucket"))
+
+	assert.Equal(&apttransports3go.BucketConfig{
+		Region:      "us-west-2",
+		Profile:     "my-profile",
+		PartSize:    16 * 1024 * 1024,
+		Concurrency: 4,
+	}, cfg.Bucket("my-bucket"))
+
+	assert.Equal(&apttransports3go.BucketConfig{
+		Region:       "ap-northeast-1",
+		Endpoint:     "http://localhost:9000",
+		UsePathStyle: true,
+		PartSize:     16 * 1024 * 1024,
+		Concurrency:  4,
+	}, cfg.Bucket("minio-bucket"))
+}
+
+func TestConfigure_CaseInsensitive(t *testing.T) {
+	assert := assert.New(t)
+	header := map[string][]string{
+		"Config-Item": {
+			"acquire::S3::Region=ap-northeast-1",
+			"Acquire::s3::ENDPOINT::my-bucket=http://localhost:9000",
+		},
+	}
+
+	ctx := log.Logger.WithContext(context.Background())
+	cfg, err := apttransports3go.Configure(ctx, header)
+	assert.NoError(err)
+	assert.Equal("ap-northeast-1", cfg.Global.Region)
+	assert.Equal("http://localhost:9000", cfg.Bucket("my-bucket").Endpoint)
+}
+
+func TestConfigure_BadBucketItem(t *testing.T) {
+	assert := assert.New(t)
+	header := map[string][]string{
+		"Config-Item": {"Acquire::s3::DisableTLS::my-bucket=maybe"},
+	}
+
+	ctx := log.Logger.WithContext(context.Background())
+	_, err := apttransports3go.Configure(ctx, header)
+	assert.EqualError(err, "bad DisableTLS: invalid boolean: maybe: my-bucket")
+}
+
+func TestConfigure_AssumeRole(t *testing.T) {
+	assert := assert.New(t)
+	header := map[string][]string{
+		"Config-Item": {
+			"Acquire::s3::Profile=default",
+			"Acquire::s3::RoleArn=arn:aws:iam::123456789012:role/apt",
+			"Acquire::s3::ExternalId=my-external-id",
+			"Acquire::s3::RoleSessionName=my-session",
+		},
+	}
+
+	ctx := log.Logger.WithContext(context.Background())
+	cfg, err := apttransports3go.Configure(ctx, header)
+	assert.NoError(err)
+	assert.Equal(apttransports3go.BucketConfig{
+		Profile:         "default",
+		RoleArn:         "arn:aws:iam::123456789012:role/apt",
+		ExternalID:      "my-external-id",
+		RoleSessionName: "my-session",
+		PartSize:        16 * 1024 * 1024,
+		Concurrency:     4,
+	}, cfg.Global)
+}
+
+func TestConfigure_AuthConf(t *testing.T) {
+	assert := assert.New(t)
+
+	tt := []struct {
+		items         []string
+		authConf      string
+		authConfParts string
+	}{
+		{nil, "/etc/apt/auth.conf", "/etc/apt/auth.conf.d"},
+		{[]string{"Dir=/tmp/root/"}, "/tmp/root/etc/apt/auth.conf", "/tmp/root/etc/apt/auth.conf.d"},
+		{[]string{"Dir::Etc=/opt/apt", "Dir::Etc::netrc=netrc"}, "/opt/apt/netrc", "/opt/apt/auth.conf.d"},
+		{[]string{"Dir::Etc::netrcparts=/run/auth.conf.d"}, "/etc/apt/auth.conf", "/run/auth.conf.d"},
+	}
+
+	for _, t := range tt {
+		ctx := log.Logger.WithContext(context.Background())
+		cfg, err := apttransports3go.Configure(ctx, map[string][]string{"Config-Item": t.items})
+		assert.NoError(err)
+		assert.Equal(t.authConf, cfg.AuthConf)
+		assert.Equal(t.authConfParts, cfg.AuthConfParts)
+	}
+}
+
+func TestConfigure_Retries(t *testing.T) {
+	assert := assert.New(t)
+	header := map[string][]string{
+		"Config-Item": {
+			"Acquire::Retries=3",
+			"Acquire::Retries::Delay::Maximum=10",
+		},
+	}
+
+	ctx := log.Logger.WithContext(context.Background())
+	cfg, err := apttransports3go.Configure(ctx, header)
+	assert.NoError(err)
+	assert.Equal(3, cfg.Retries)
+	assert.Equal(10*time.Second, cfg.MaxRetryDelay)
+}
+
+func TestConfig_RetryDelay(t *testing.T) {
+	assert := assert.New(t)
+	cfg := apttransports3go.NewConfig()
+
+	for attempt := range 100 {
+		delay := apttransports3go.ConfigRetryDelay(cfg, attempt)
+		assert.GreaterOrEqual(delay, time.Duration(0))
+		assert.Less(delay, cfg.MaxRetryDelay)
+		assert.Less(delay, cfg.RetryDelay<<min(attempt, 10))
+	}
+
+	cfg.RetryDelay = 0
+	assert.Equal(time.Duration(0), apttransports3go.ConfigRetryDelay(cfg, 1))
+}
+
+func TestConfigure_Multipart(t *testing.T) {
+	assert := assert.New(t)
+	header := map[string][]string{
+		"Config-Item": {
+			"Acquire::s3::PartSize=8M",
+			"Acquire::s3::Concurrency=8",
+			"Acquire::s3::Concurrency::my-bucket=1",
+		},
+	}
+
+	ctx := log.Logger.WithContext(context.Background())
+	cfg, err := apttransports3go.Configure(ctx, header)
+	assert.NoError(err)
+	assert.Equal(int64(8*1024*1024), cfg.Global.PartSize)
+	assert.Equal(8, cfg.Global.Concurrency)
+	assert.Equal(int64(8*1024*1024), cfg.Bucket("my-bucket").PartSize)
+	assert.Equal(1, cfg.Bucket("my-bucket").Concurrency)
+}
+
+func TestConfigure_BadPartSize(t *testing.T) {
+	assert := assert.New(t)
+	header := map[string][]string{
+		"Config-Item": {"Acquire::s3::PartSize=1K"},
+	}
+
+	ctx := log.Logger.WithContext(context.Background())
+	_, err := apttransports3go.Configure(ctx, header)
+	assert.EqualError(err, "bad PartSize: 1K")
+}
+
+func TestConfigure_CacheDir(t *testing.T) {
+	assert := assert.New(t)
+	header := map[string][]string{
+		"Config-Item": {
+			"Acquire::s3::CacheDir=/var/cache/apt-s3",
+			"Acquire::s3::CacheDir::my-bucket=",
+		},
+	}
+
+	ctx := log.Logger.WithContext(context.Background())
+	cfg, err := apttransports3go.Configure(ctx, header)
+	assert.NoError(err)
+	assert.Equal("/var/cache/apt-s3", cfg.Global.CacheDir)
+	assert.Equal("", cfg.Bucket("my-bucket").CacheDir)
+}
+
+func TestConfigure_Debug(t *testing.T) {
+	assert := assert.New(t)
+	header := map[string][]string{
+		"Config-Item": {"Debug::Acquire::s3=1"},
+	}
+
+	ctx := log.Logger.WithContext(context.Background())
+	cfg, err := apttransports3go.Configure(ctx, header)
+	assert.NoError(err)
+	assert.True(cfg.Debug)
+
+	_, err = apttransports3go.Configure(ctx, map[string][]string{
+		"Config-Item": {"Debug::Acquire::s3=maybe"},
+	})
+	assert.ErrorContains(err, "bad Debug::Acquire::s3: ")
+}
+
+func TestConfigure_AccessPoint(t *testing.T) {
+	assert := assert.New(t)
+	header := map[string][]string{
+		"Config-Item": {
+			"Acquire::s3::AccessPoint::my-alias=arn:aws:s3::123456789012:accesspoint/mfzwi23gnjvgw.mrap",
+		},
+	}
+
+	ctx := log.Logger.WithContext(context.Background())
+	cfg, err := apttransports3go.Configure(ctx, header)
+	assert.NoError(err)
+	assert.Equal("arn:aws:s3::123456789012:accesspoint/mfzwi23gnjvgw.mrap", cfg.Bucket("my-alias").AccessPoint)
+	assert.Equal("", cfg.Global.AccessPoint)
+}
+
+func TestConfigure_BadAccessPoint(t *testing.T) {
+	assert := assert.New(t)
+	ctx := log.Logger.WithContext(context.Background())
+
+	_, err := apttransports3go.Configure(ctx, map[string][]string{
+		"Config-Item": {"Acquire::s3::AccessPoint=arn:aws:s3::123456789012:accesspoint/mfzwi23gnjvgw.mrap"},
+	})
+	assert.EqualError(err, "AccessPoint must be scoped to an alias: Acquire::s3::AccessPoint::<alias>")
+
+	_, err = apttransports3go.Configure(ctx, map[string][]string{
+		"Config-Item": {"Acquire::s3::AccessPoint::my-alias=my-ap"},
+	})
+	assert.EqualError(err, "bad AccessPoint: my-ap: my-alias")
+}
+
+func TestConfigure_RequesterPays(t *testing.T) {
+	assert := assert.New(t)
+	header := map[string][]string{
+		"Config-Item": {
+			"Acquire::s3::RequesterPays::vendor-bucket=true",
+		},
+	}
+
+	ctx := log.Logger.WithContext(context.Background())
+	cfg, err := apttransports3go.Configure(ctx, header)
+	assert.NoError(err)
+	assert.False(cfg.Global.RequesterPays)
+	assert.True(cfg.Bucket("vendor-bucket").RequesterPays)
+
+	_, err = apttransports3go.Configure(ctx, map[string][]string{
+		"Config-Item": {"Acquire::s3::RequesterPays=maybe"},
+	})
+	assert.ErrorContains(err, "bad RequesterPays: ")
+}
+
+func TestConfigure_ExpectedBucketOwner(t *testing.T) {
+	assert := assert.New(t)
+	header := map[string][]string{
+		"Config-Item": {
+			"Acquire::s3::ExpectedBucketOwner=123456789012",
+			"Acquire::s3::ExpectedBucketOwner::vendor-bucket=210987654321",
+		},
+	}
+
+	ctx := log.Logger.WithContext(context.Background())
+	cfg, err := apttransports3go.Configure(ctx, header)
+	assert.NoError(err)
+	assert.Equal("123456789012", cfg.Global.ExpectedBucketOwner)
+	assert.Equal("210987654321", cfg.Bucket("vendor-bucket").ExpectedBucketOwner)
+
+	_, err = apttransports3go.Configure(ctx, map[string][]string{
+		"Config-Item": {"Acquire::s3::ExpectedBucketOwner=my-account"},
+	})
+	assert.EqualError(err, "bad ExpectedBucketOwner: my-account")
+}
+
+func TestApplyRequestOptions(t *testing.T) {
+	assert := assert.New(t)
+	bc := apttransports3go.NewConfig().Global
+	bc.RequesterPays = true
+	bc.ExpectedBucketOwner = "123456789012"
+	bc.SSECustomerKey = "0123456789abcdef0123456789abcdef"
+	getObjInput := &s3.GetObjectInput{}
+	headObjInput := &s3.HeadObjectInput{}
+	apttransports3go.ApplyGetObjectRequestOptions(&bc, getObjInput)
+	apttransports3go.ApplyHeadObjectRequestOptions(&bc, headObjInput)
+
+	// GET and HEAD must be sent with the same options
+	for _, input := range []any{getObjInput, headObjInput} {
+		assert.EqualValues("requester", fieldValue(input, "RequestPayer"))
+		assert.Equal("123456789012", fieldValue(input, "ExpectedBucketOwner"))
+		assert.EqualValues("ENABLED", fieldValue(input, "ChecksumMode"))
+		assert.Equal("AES256", fieldValue(input, "SSECustomerAlgorithm"))
+		assert.Equal("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=", fieldValue(input, "SSECustomerKey"))
+		assert.NotEmpty(fieldValue(input, "SSECustomerKeyMD5"))
+	}
+}
@@ -1,5 +1,25 @@
 package apttransports3go
 
+import "github.com/aws/aws-sdk-go-v2/service/s3"
+
 var Read = read
 var ReadLine = readLine
 var Send = send
+var IsNotModified = isNotModified
+var ParseBool = parseBool
+var BucketConfigS3Options = (*BucketConfig).s3Options
+var ClientClient = (*Client).client
+var ParseNetrc = parseNetrc
+var ReadAuthConf = readAuthConf
+var ClassifyError = classifyError
+var ConfigRetryDelay = (*Config).retryDelay
+var ParseSize = parseSize
+var NewProgressWriter = newProgressWriter
+var FormatSize = formatSize
+var NewLogWriter = newLogWriter
+var LogWriterSetLevel = (*logWriter).setLevel
+var NewObjectChecksum = newObjectChecksum
+var ObjectChecksumVerify = (*objectChecksum).verify
+var ApplyGetObjectRequestOptions = applyRequestOptions[*s3.GetObjectInput]
+var ApplyHeadObjectRequestOptions = applyRequestOptions[*s3.HeadObjectInput]
+var AuthorizationTimeout = &authorizationTimeout
@@ -0,0 +1,142 @@
+package apttransports3go
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/rs/zerolog"
+)
+
+// uriFailure is an error reported to apt with "400 URI Failure".
+type uriFailure struct {
+	err       error
+	reason    string
+	transient bool
+}
+
+func (f *uriFailure) Error() string {
+	return f.err.Error()
+}
+
+func (f *uriFailure) Unwrap() error {
+	return f.err
+}
+
+func newURIFailure(err error) *uriFailure {
+	reason, transient := classifyError(err)
+	return &uriFailure{err: err, reason: reason, transient: transient}
+}
+
+// classifyError returns apt's FailReason for err and whether a retry may succeed.
+func classifyError(err error) (string, bool) {
+	// the same as S3's response to a request without credentials
+	if errors.Is(err, errNoCredentials) {
+		return "HttpError403", false
+	}
+
+	var apiErr smithy.APIError
+
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "NoSuchKey", "NoSuchBucket", "NoSuchVersion", "NotFound":
+			return "HttpError404", false
+		case "AccessDenied", "Forbidden", "InvalidAccessKeyId", "SignatureDoesNotMatch":
+			return "HttpError403", false
+		case "SlowDown", "Throttling", "ThrottlingException", "RequestLimitExceeded", "TooManyRequests", "RequestThrottled":
+			return "Throttling", true
+		case "RequestTimeout", "RequestTimeoutException":
+			return "Timeout", true
+		}
+	}
+
+	var respErr interface{ HTTPStatusCode() int }
+
+	if errors.As(err, &respErr) {
+		code := respErr.HTTPStatusCode()
+		transient := code >= http.StatusInternalServerError || code == http.StatusTooManyRequests || code == http.StatusRequestTimeout
+		return fmt.Sprintf("HttpError%d", code), transient
+	}
+
+	var netErr net.Error
+	var dnsErr *net.DNSError
+
+	switch {
+	case errors.As(err, &dnsErr):
+		if dnsErr.IsTemporary || dnsErr.IsTimeout {
+			return "TmpResolveFailure", true
+		}
+
+		return "ResolveFailure", false
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "Timeout", true
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "ConnectionRefused", true
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE), errors.Is(err, io.ErrUnexpectedEOF):
+		return "ConnectionReset", true
+	}
+
+	return "", false
+}
+
+// bucketHeader is implemented by an S3API that can also send HeadBucket, e.g. Client.
+type bucketHeader interface {
+	HeadBucket(ctx context.Context, params *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error)
+}
+
+// explainForbidden explains a 403, which S3 also returns for a bucket of an unexpected owner
+// and for a Requester Pays bucket requested without RequestPayer.
+func explainForbidden(ctx context.Context, api S3API, bucket string, failure *uriFailure, bc *BucketConfig) *uriFailure {
+	// nothing to explain when the request was not even sent
+	if failure.reason != "HttpError403" || errors.Is(failure, errNoCredentials) {
+		return failure
+	}
+
+	if bc.ExpectedBucketOwner != "" && isOwnerMismatch(ctx, api, bucket, bc) {
+		return &uriFailure{
+			err:    fmt.Errorf("%w (the bucket is not owned by %s)", failure.err, bc.ExpectedBucketOwner),
+			reason: "BucketOwnerMismatch",
+		}
+	}
+
+	// a Requester Pays bucket denies the request like a missing permission, not like bad credentials
+	var apiErr smithy.APIError
+
+	if !bc.RequesterPays && errors.As(failure, &apiErr) && apiErr.ErrorCode() == "AccessDenied" {
+		return &uriFailure{
+			err:    fmt.Errorf("%w (set Acquire::s3::RequesterPays if the bucket is Requester Pays)", failure.err),
+			reason: failure.reason,
+		}
+	}
+
+	return failure
+}
+
+// isOwnerMismatch confirms that the bucket is accessible but not owned by the expected owner:
+// HeadBucket succeeds without ExpectedBucketOwner and is forbidden with it.
+func isOwnerMismatch(ctx context.Context, api S3API, bucket string, bc *BucketConfig) bool {
+	hb, ok := api.(bucketHeader)
+
+	if !ok {
+		return false
+	}
+
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Str("bucket", bucket).Msg("check bucket owner")
+
+	if _, err := hb.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)}); err != nil {
+		logger.Debug().Err(err).Msg("failed to head bucket")
+		return false
+	}
+
+	_, err := hb.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket), ExpectedBucketOwner: bc.expectedBucketOwner()})
+	var respErr interface{ HTTPStatusCode() int }
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusForbidden
+}
@@ -0,0 +1,47 @@
+package apttransports3go_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/stretchr/testify/assert"
+	apttransports3go "github.com/winebarrel/apt-transport-s3-go"
+)
+
+func TestClassifyError_OK(t *testing.T) {
+	assert := assert.New(t)
+
+	tt := []struct {
+		err       error
+		reason    string
+		transient bool
+	}{
+		{errors.New("error"), "", false},
+		{newResponseError(404, &types.NoSuchKey{}), "HttpError404", false},
+		{newResponseError(404, &smithy.GenericAPIError{Code: "NotFound"}), "HttpError404", false},
+		{newResponseError(403, &smithy.GenericAPIError{Code: "AccessDenied"}), "HttpError403", false},
+		{newResponseError(503, &smithy.GenericAPIError{Code: "SlowDown"}), "Throttling", true},
+		{newResponseError(500, &smithy.GenericAPIError{Code: "InternalError"}), "HttpError500", true},
+		{newResponseError(400, &smithy.GenericAPIError{Code: "InvalidArgument"}), "HttpError400", false},
+		{fmt.Errorf("wrapped: %w", context.DeadlineExceeded), "Timeout", true},
+		{&net.OpError{Op: "read", Err: syscall.ECONNRESET}, "ConnectionReset", true},
+		{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, "ConnectionRefused", true},
+		{io.ErrUnexpectedEOF, "ConnectionReset", true},
+		{&net.DNSError{Err: "no such host", IsNotFound: true}, "ResolveFailure", false},
+		{&net.DNSError{Err: "server misbehaving", IsTemporary: true}, "TmpResolveFailure", true},
+		{context.Canceled, "", false},
+	}
+
+	for _, t := range tt {
+		reason, transient := apttransports3go.ClassifyError(t.err)
+		assert.Equal(t.reason, reason, t.err.Error())
+		assert.Equal(t.transient, transient, t.err.Error())
+	}
+}
@@ -0,0 +1,188 @@
+package apttransports3go
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// atomicFile is a temporary file that replaces the target file only when committed.
+type atomicFile struct {
+	*os.File
+	path      string
+	committed bool
+}
+
+func createAtomicFile(path string) (*atomicFile, error) {
+	// create the file in the same directory so that it can be renamed over the target
+	fp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fp.Chmod(0644); err != nil {
+		fp.Close()
+		os.Remove(fp.Name())
+		return nil, err
+	}
+
+	return &atomicFile{File: fp, path: path}, nil
+}
+
+func (f *atomicFile) commit(mtime time.Time) error {
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chtimes(f.Name(), mtime, mtime); err != nil {
+		return err
+	}
+
+	if err := os.Rename(f.Name(), f.path); err != nil {
+		return err
+	}
+
+	f.committed = true
+	return nil
+}
+
+// cleanup removes the temporary file unless it has been committed.
+func (f *atomicFile) cleanup() {
+	if f.committed {
+		return
+	}
+
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// partialFile is a download in progress at "<Filename>.s3partial", which replaces the target file only when committed.
+// It is kept when the download is interrupted so that the next fetch can resume it.
+type partialFile struct {
+	*os.File
+	target string
+	done   bool
+}
+
+// partialMeta identifies the object version of a partial download.
+type partialMeta struct {
+	ETag         string    `json:"etag"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+func partialPath(fn string) string {
+	return fn + ".s3partial"
+}
+
+func partialMetaPath(fn string) string {
+	return fn + ".s3partial.json"
+}
+
+// resumePoint returns the size of the partial download of the object, or 0 if it cannot be resumed.
+func resumePoint(fn string, size int64, etag string, lastModified time.Time) int64 {
+	data, err := os.ReadFile(partialMetaPath(fn))
+
+	if err != nil {
+		return 0
+	}
+
+	var meta partialMeta
+
+	if err := json.Unmarshal(data, &meta); err != nil || etag == "" || meta.ETag != etag || !meta.LastModified.Equal(lastModified) {
+		return 0
+	}
+
+	fi, err := os.Stat(partialPath(fn))
+
+	if err != nil || fi.Size() <= 0 || fi.Size() >= size {
+		return 0
+	}
+
+	return fi.Size()
+}
+
+// openPartialFile opens the partial download of the object to append to it after offset bytes,
+// or starts a new one if offset is 0.
+func openPartialFile(fn string, offset int64, etag string, lastModified time.Time) (*partialFile, error) {
+	if offset > 0 {
+		fp, err := os.OpenFile(partialPath(fn), os.O_RDWR, 0)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &partialFile{File: fp, target: fn}, nil
+	}
+
+	removePartial(fn)
+	meta, err := json.Marshal(partialMeta{ETag: etag, LastModified: lastModified})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the data is never left without its object version
+	if err := os.WriteFile(partialMetaPath(fn), meta, 0644); err != nil {
+		return nil, err
+	}
+
+	fp, err := os.OpenFile(partialPath(fn), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fp.Chmod(0644); err != nil {
+		fp.Close()
+		return nil, err
+	}
+
+	return &partialFile{File: fp, target: fn}, nil
+}
+
+func (f *partialFile) commit(mtime time.Time) error {
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chtimes(f.Name(), mtime, mtime); err != nil {
+		return err
+	}
+
+	if err := os.Rename(f.Name(), f.target); err != nil {
+		return err
+	}
+
+	f.done = true
+	os.Remove(partialMetaPath(f.target))
+	return nil
+}
+
+// discard removes the partial download, e.g. when it turns out to be corrupted.
+func (f *partialFile) discard() {
+	f.Close()
+	removePartial(f.target)
+	f.done = true
+}
+
+// close keeps the partial download for resuming unless it has been committed or discarded.
+func (f *partialFile) close() {
+	if !f.done {
+		f.Close()
+	}
+}
+
+func removePartial(fn string) {
+	os.Remove(partialPath(fn))
+	os.Remove(partialMetaPath(fn))
+}
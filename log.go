@@ -0,0 +1,119 @@
+package apttransports3go
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+)
+
+// logWriter mirrors log events to apt as "101 Log".
+type logWriter struct {
+	w     io.Writer
+	level atomic.Int32
+}
+
+func newLogWriter(w io.Writer) *logWriter {
+	lw := &logWriter{w: w}
+	lw.setLevel(zerolog.WarnLevel)
+	return lw
+}
+
+func (lw *logWriter) setLevel(level zerolog.Level) {
+	lw.level.Store(int32(level))
+}
+
+func (lw *logWriter) Write(p []byte) (int, error) {
+	return lw.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (lw *logWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	if level < zerolog.Level(lw.level.Load()) {
+		return len(p), nil
+	}
+
+	var fields map[string]any
+
+	if err := json.Unmarshal(p, &fields); err != nil {
+		return len(p), nil
+	}
+
+	// the context has no logger so that sending the log is not logged again
+	send(context.Background(), lw.w, StatusLog, map[string]string{"Message": formatLog(level, fields)})
+	return len(p), nil
+}
+
+// loggerWriter writes log events to the logger, which keeps its own output, level and context fields.
+type loggerWriter struct {
+	logger *zerolog.Logger
+}
+
+func (lw *loggerWriter) Write(p []byte) (int, error) {
+	return lw.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (lw *loggerWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	var fields map[string]json.RawMessage
+
+	if err := json.Unmarshal(p, &fields); err != nil {
+		return len(p), nil
+	}
+
+	var msg string
+	json.Unmarshal(fields[zerolog.MessageFieldName], &msg) //nolint:errcheck
+	keys := make([]string, 0, len(fields))
+
+	for k := range fields {
+		switch k {
+		case zerolog.MessageFieldName, zerolog.LevelFieldName:
+			continue
+		}
+
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	// WithLevel does not exit or panic even at fatal or panic level
+	e := lw.logger.WithLevel(level)
+
+	for _, k := range keys {
+		e = e.RawJSON(k, fields[k])
+	}
+
+	e.Msg(msg)
+	return len(p), nil
+}
+
+func formatLog(level zerolog.Level, fields map[string]any) string {
+	var buf strings.Builder
+	buf.WriteString(level.String())
+
+	if msg, ok := fields[zerolog.MessageFieldName]; ok {
+		fmt.Fprintf(&buf, ": %v", msg)
+	}
+
+	keys := make([]string, 0, len(fields))
+
+	for k := range fields {
+		switch k {
+		case zerolog.MessageFieldName, zerolog.LevelFieldName, zerolog.TimestampFieldName, "pid":
+			continue
+		}
+
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		fmt.Fprintf(&buf, " %s=%v", k, fields[k])
+	}
+
+	// a header value must be a single line
+	return strings.ReplaceAll(buf.String(), "\n", " ")
+}
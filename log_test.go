@@ -0,0 +1,42 @@
+package apttransports3go_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	apttransports3go "github.com/winebarrel/apt-transport-s3-go"
+)
+
+func TestLogWriter(t *testing.T) {
+	assert := assert.New(t)
+	var buf strings.Builder
+	lw := apttransports3go.NewLogWriter(&buf)
+	logger := zerolog.New(lw)
+	logger.Debug().Msg("debug message")
+	logger.Info().Msg("info message")
+	logger.Warn().Str("uri", "s3://example.com/key").Str("error", "line1\nline2").Msg("warn message")
+
+	assert.Equal(`101 Log
+Message: warn: warn message error=line1 line2 uri=s3://example.com/key
+
+`, buf.String())
+}
+
+func TestLogWriter_Debug(t *testing.T) {
+	assert := assert.New(t)
+	defer zerolog.SetGlobalLevel(zerolog.GlobalLevel())
+	zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	var buf strings.Builder
+	lw := apttransports3go.NewLogWriter(&buf)
+	apttransports3go.LogWriterSetLevel(lw, zerolog.DebugLevel)
+	logger := zerolog.New(lw).Level(zerolog.DebugLevel).With().Int("pid", 1).Timestamp().Logger()
+	logger.Trace().Msg("trace message")
+	logger.Debug().Int("attempt", 1).Msg("debug message")
+
+	assert.Equal(`101 Log
+Message: debug: debug message attempt=1
+
+`, buf.String())
+}
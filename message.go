@@ -40,6 +40,20 @@ var (
 	StatusMediaChanged             = newStatus(603, "Media Changed")
 )
 
+// headers whose values are not logged
+var secretHeaders = map[string]bool{"password": true}
+
+// redactHeader hides the value of a secret header line for logging.
+func redactHeader(line string) string {
+	name, _, ok := strings.Cut(line, ":")
+
+	if ok && secretHeaders[strings.ToLower(strings.TrimSpace(name))] {
+		return name + ": ********"
+	}
+
+	return line
+}
+
 func send(ctx context.Context, w io.Writer, code Status, header map[string]string) {
 	logger := zerolog.Ctx(ctx)
 	status, ok := statusByCode[code]
@@ -48,7 +62,9 @@ func send(ctx context.Context, w io.Writer, code Status, header map[string]strin
 		log.Fatal().Msgf("status not found: %d", code)
 	}
 
-	fmt.Fprintf(w, "%d %s\n", code, status)
+	// build the whole message first so that it is written at once
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "%d %s\n", code, status)
 	keys := make([]string, 0, len(header))
 
 	for k := range header {
@@ -59,11 +75,12 @@ func send(ctx context.Context, w io.Writer, code Status, header map[string]strin
 
 	for _, k := range keys {
 		v := header[k]
-		fmt.Fprintf(w, "%s: %s\n", k, v)
+		fmt.Fprintf(&buf, "%s: %s\n", k, v)
 		logger.Debug().Int("code", int(code)).Str("header", k+":"+v).Msg("send")
 	}
 
-	fmt.Fprintf(w, "\n")
+	buf.WriteString("\n")
+	io.WriteString(w, buf.String()) //nolint:errcheck
 }
 
 func read(ctx context.Context, r *bufio.Reader) (Status, string, map[string][]string, error) {
@@ -104,7 +121,7 @@ func read(ctx context.Context, r *bufio.Reader) (Status, string, map[string][]st
 	for {
 		// read header
 		line, err := readLine(r)
-		logger.Debug().Err(err).Str("line", line).Msg("read header")
+		logger.Debug().Err(err).Str("line", redactHeader(line)).Msg("read header")
 
 		if err == io.EOF {
 			return Status(code), status, header, err
@@ -0,0 +1,296 @@
+package apttransports3go
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/rs/zerolog"
+)
+
+// objectSize returns the size of the whole object from the GetObject response.
+func objectSize(obj *s3.GetObjectOutput) int64 {
+	// e.g. "bytes 100-199/1000"
+	if cr := aws.ToString(obj.ContentRange); cr != "" {
+		if i := strings.LastIndex(cr, "/"); i >= 0 {
+			if size, err := strconv.ParseInt(cr[i+1:], 10, 64); err == nil {
+				return size
+			}
+		}
+	}
+
+	return aws.ToInt64(obj.ContentLength)
+}
+
+// bodyEnd returns the offset in the object just after obj's body.
+func bodyEnd(obj *s3.GetObjectOutput) int64 {
+	var start, end int64
+
+	// e.g. "bytes 100-199/1000"
+	if _, err := fmt.Sscanf(aws.ToString(obj.ContentRange), "bytes %d-%d/", &start, &end); err == nil {
+		return end + 1
+	}
+
+	return objectSize(obj)
+}
+
+// firstRange returns the Range of the first GET from offset of the object whose size is maxSize (0 if unknown).
+// Only when the object is known to be larger than a part, the first part is requested by itself;
+// otherwise the GET is plain so that it works for an empty object and returns the full-object checksum.
+func firstRange(offset int64, maxSize int64, bc *BucketConfig) *string {
+	if bc.Concurrency > 1 && bc.PartSize > 0 && maxSize-offset > bc.PartSize {
+		return aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+bc.PartSize-1))
+	}
+
+	if offset > 0 {
+		return aws.String(fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	return nil
+}
+
+type part struct {
+	start int64
+	end   int64
+	buf   bytes.Buffer
+	err   error
+	done  chan struct{}
+}
+
+func (p *part) length() int64 {
+	return p.end - p.start + 1
+}
+
+// splitParts splits the object from start to size into parts.
+func splitParts(start int64, size int64, partSize int64) []*part {
+	if partSize <= 0 {
+		partSize = size - start
+	}
+
+	parts := []*part{}
+
+	for ; start < size; start += partSize {
+		parts = append(parts, &part{start: start, end: min(start+partSize, size) - 1, done: make(chan struct{})})
+	}
+
+	return parts
+}
+
+// copyBody copies obj's body, which covers the object from offset to bodyEnd(obj), to w.
+// When the object turns out to be larger than a part, only the first part is read from the body
+// and the rest is left to be fetched in parts.
+func copyBody(w io.Writer, obj *s3.GetObjectOutput, offset int64, bc *BucketConfig) (int64, error) {
+	length := bodyEnd(obj) - offset
+	var written int64
+	var err error
+
+	if bc.Concurrency > 1 && bc.PartSize > 0 && length > bc.PartSize {
+		length = bc.PartSize
+		written, err = io.CopyN(w, obj.Body, length)
+
+		if err == io.EOF {
+			err = nil
+		}
+	} else {
+		written, err = io.Copy(&limitWriter{w: w, n: length}, obj.Body)
+	}
+
+	if err == nil && written < length {
+		err = fmt.Errorf("%w: got %d bytes, but expected %d bytes", io.ErrUnexpectedEOF, written, length)
+	}
+
+	return written, err
+}
+
+// writeObject writes the rest of the object from offset to f, which is positioned at offset,
+// and hashes it to sums in order.
+// obj's body is written as it comes. When it does not reach the end of the object,
+// the following parts are fetched with concurrent ranged GETs and written at their offsets in f,
+// and then hashed by reading them back, so that no part is held in memory.
+// If a part fails, f is truncated after the parts written contiguously so that the download can be resumed.
+func writeObject(ctx context.Context, f *os.File, sums io.Writer, progress io.Writer, api S3API, input *s3.GetObjectInput, obj *s3.GetObjectOutput, offset int64, bc *BucketConfig) (int64, error) {
+	size := objectSize(obj)
+	written, err := copyBody(io.MultiWriter(f, sums, progress), obj, offset, bc)
+
+	if err != nil {
+		return written, err
+	}
+
+	obj.Body.Close()
+	start := offset + written
+	parts := splitParts(start, size, bc.PartSize)
+
+	if len(parts) == 0 {
+		return written, nil
+	}
+
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Int64("size", size).Int("parts", len(parts)+1).Msg("start multipart download")
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sem := make(chan struct{}, max(bc.Concurrency, 1))
+	var wg sync.WaitGroup
+
+	for _, p := range parts {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+
+		if ctx.Err() != nil {
+			p.err = ctx.Err()
+			continue
+		}
+
+		wg.Go(func() {
+			defer func() { <-sem }()
+			p.err = fetchPart(ctx, api, input, aws.ToString(obj.ETag), p, io.MultiWriter(io.NewOffsetWriter(f, p.start), progress))
+
+			// stop the other parts
+			if p.err != nil {
+				cancel()
+			}
+		})
+	}
+
+	wg.Wait()
+
+	for _, p := range parts {
+		if p.err == nil {
+			written += p.length()
+			continue
+		}
+
+		// keep only the contiguous data to be resumed
+		if err := f.Truncate(p.start); err != nil {
+			logger.Debug().Err(err).Msg("failed to truncate file")
+		}
+
+		return written, fmt.Errorf("failed to get part %d-%d: %w", p.start, p.end, partError(parts))
+	}
+
+	if _, err := io.Copy(sums, io.NewSectionReader(f, start, size-start)); err != nil {
+		return written, fmt.Errorf("failed to read parts: %w", err)
+	}
+
+	return written, nil
+}
+
+// partError returns the error that made the parts fail rather than the cancellation caused by it.
+func partError(parts []*part) error {
+	var err error
+
+	for _, p := range parts {
+		if p.err == nil {
+			continue
+		} else if !errors.Is(p.err, context.Canceled) {
+			return p.err
+		} else if err == nil {
+			err = p.err
+		}
+	}
+
+	return err
+}
+
+// copyObject copies the rest of the object from offset to w, which sees a sequential stream (e.g. stdout).
+// obj's body is copied first. When it does not reach the end of the object,
+// the following parts are fetched with concurrent ranged GETs, buffered and written in order.
+// At most bc.Concurrency parts (Concurrency × PartSize bytes) are held in memory at a time.
+func copyObject(ctx context.Context, w io.Writer, api S3API, input *s3.GetObjectInput, obj *s3.GetObjectOutput, offset int64, bc *BucketConfig) (int64, error) {
+	size := objectSize(obj)
+	written, err := copyBody(w, obj, offset, bc)
+
+	if err != nil {
+		return written, err
+	}
+
+	obj.Body.Close()
+	parts := splitParts(offset+written, size, bc.PartSize)
+
+	if len(parts) == 0 {
+		return written, nil
+	}
+
+	logger := zerolog.Ctx(ctx)
+	logger.Debug().Int64("size", size).Int("parts", len(parts)+1).Msg("start multipart download")
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sem := make(chan struct{}, max(bc.Concurrency, 1))
+
+	go func() {
+		for _, p := range parts {
+			// a slot is released when the part has been written to w
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			go func() {
+				defer close(p.done)
+				p.buf.Grow(int(p.length()))
+				p.err = fetchPart(ctx, api, input, aws.ToString(obj.ETag), p, &p.buf)
+			}()
+		}
+	}()
+
+	for _, p := range parts {
+		select {
+		case <-p.done:
+		case <-ctx.Done():
+			return written, ctx.Err()
+		}
+
+		if p.err != nil {
+			return written, fmt.Errorf("failed to get part %d-%d: %w", p.start, p.end, p.err)
+		}
+
+		n, err := p.buf.WriteTo(w)
+		written += n
+		// release the buffer before fetching the next part
+		p.buf = bytes.Buffer{}
+		<-sem
+
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// fetchPart gets the part with a ranged GET and copies it to w.
+func fetchPart(ctx context.Context, api S3API, input *s3.GetObjectInput, etag string, p *part, w io.Writer) error {
+	partInput := *input
+	partInput.Range = aws.String(fmt.Sprintf("bytes=%d-%d", p.start, p.end))
+	partInput.IfModifiedSince = nil
+
+	// make sure all parts come from the same object
+	if etag != "" {
+		partInput.IfMatch = aws.String(etag)
+	}
+
+	obj, err := api.GetObject(ctx, &partInput)
+
+	if err != nil {
+		return err
+	}
+
+	defer obj.Body.Close()
+	n, err := io.Copy(&limitWriter{w: w, n: p.length()}, obj.Body)
+
+	if err == nil && n != p.length() {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return err
+}
@@ -0,0 +1,63 @@
+package apttransports3go
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// parseBucketPatterns parses a list of "<bucket glob>[/<key prefix>]" separated by commas or spaces.
+func parseBucketPatterns(value string) ([]string, error) {
+	patterns := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+
+	for _, pattern := range patterns {
+		bucket, _ := splitBucketPattern(pattern)
+
+		if _, err := path.Match(bucket, ""); err != nil {
+			return nil, fmt.Errorf("%w: %s", err, pattern)
+		}
+	}
+
+	return patterns, nil
+}
+
+func splitBucketPattern(pattern string) (string, string) {
+	// an access point ARN contains "/"
+	if strings.HasPrefix(pattern, "arn:") {
+		if bucket, prefix, err := splitARN(pattern); err == nil {
+			return bucket, prefix
+		}
+	}
+
+	bucket, prefix, _ := strings.Cut(pattern, "/")
+	return bucket, prefix
+}
+
+func matchBucketPattern(pattern string, bucket string, key string) bool {
+	bucketPattern, prefix := splitBucketPattern(pattern)
+	matched, _ := path.Match(bucketPattern, bucket)
+	return matched && strings.HasPrefix(key, prefix)
+}
+
+// checkPolicy returns an error if the object is not allowed by AllowedBuckets and DeniedBuckets.
+func (cfg *Config) checkPolicy(bucket string, key string) error {
+	for _, pattern := range cfg.DeniedBuckets {
+		if matchBucketPattern(pattern, bucket, key) {
+			return fmt.Errorf("s3://%s/%s is denied by Acquire::s3::DeniedBuckets (%s)", bucket, key, pattern)
+		}
+	}
+
+	if len(cfg.AllowedBuckets) == 0 {
+		return nil
+	}
+
+	for _, pattern := range cfg.AllowedBuckets {
+		if matchBucketPattern(pattern, bucket, key) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("s3://%s/%s is not allowed by Acquire::s3::AllowedBuckets", bucket, key)
+}
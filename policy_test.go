@@ -0,0 +1,142 @@
+package apttransports3go_test
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	apttransports3go "github.com/winebarrel/apt-transport-s3-go"
+)
+
+func TestConfigure_BucketPolicy(t *testing.T) {
+	assert := assert.New(t)
+	header := map[string][]string{
+		"Config-Item": {
+			"Acquire::s3::AllowedBuckets::=my-repo",
+			"Acquire::s3::AllowedBuckets::=vendor-*/debian/",
+			"Acquire::s3::DeniedBuckets=vendor-test, vendor-*/debian/private/",
+		},
+	}
+
+	ctx := log.Logger.WithContext(context.Background())
+	cfg, err := apttransports3go.Configure(ctx, header)
+	assert.NoError(err)
+	assert.Equal([]string{"my-repo", "vendor-*/debian/"}, cfg.AllowedBuckets)
+	assert.Equal([]string{"vendor-test", "vendor-*/debian/private/"}, cfg.DeniedBuckets)
+}
+
+func TestConfigure_BadBucketPolicy(t *testing.T) {
+	assert := assert.New(t)
+	ctx := log.Logger.WithContext(context.Background())
+
+	_, err := apttransports3go.Configure(ctx, map[string][]string{
+		"Config-Item": {"Acquire::s3::AllowedBuckets=my-[repo"},
+	})
+	assert.EqualError(err, "bad AllowedBuckets: syntax error in pattern: my-[repo")
+
+	_, err = apttransports3go.Configure(ctx, map[string][]string{
+		"Config-Item": {"Acquire::s3::DeniedBuckets::my-bucket=other"},
+	})
+	assert.EqualError(err, "bucket policy cannot be scoped to a bucket: Acquire::s3::DeniedBuckets::my-bucket")
+}
+
+func TestFetch_BucketPolicy(t *testing.T) {
+	assert := assert.New(t)
+	cfg := apttransports3go.NewConfig()
+	cfg.AllowedBuckets = []string{"my-repo", "vendor-*/debian/", "arn:aws:s3:us-west-2:123456789012:accesspoint/my-ap"}
+	cfg.DeniedBuckets = []string{"vendor-test", "vendor-*/debian/private/"}
+
+	tt := []struct {
+		uri     string
+		allowed bool
+	}{
+		{"s3://my-repo/dists/stable/Release", true},
+		{"s3://my-repo-typo/dists/stable/Release", false},
+		{"s3://vendor-a/debian/dists/stable/Release", true},
+		{"s3://vendor-a/ubuntu/dists/stable/Release", false},
+		{"s3://vendor-a/debian/private/dists/stable/Release", false},
+		{"s3://vendor-test/debian/dists/stable/Release", false},
+		{"s3://arn:aws:s3:us-west-2:123456789012:accesspoint/my-ap/dists/stable/Release", true},
+		{"s3://arn:aws:s3:us-west-2:123456789012:accesspoint/other-ap/dists/stable/Release", false},
+	}
+
+	for _, t_ := range tt {
+		header := map[string][]string{
+			"URI":      {t_.uri},
+			"Filename": {filepath.Join(t.TempDir(), "Release")},
+		}
+
+		var buf strings.Builder
+		ctx := log.Logger.WithContext(context.Background())
+		api := &MockS3API{
+			Body:          io.NopCloser(strings.NewReader("apt body")),
+			ContentLength: 8,
+		}
+		err := apttransports3go.Fetch(ctx, &buf, api, cfg, header)
+		assert.NoError(err)
+
+		if t_.allowed {
+			assert.Contains(buf.String(), "201 URI Done\n", t_.uri)
+		} else {
+			assert.Contains(buf.String(), "400 URI Failure\nFailReason: PolicyDenied\n", t_.uri)
+			// no request is sent
+			assert.Nil(api.GetObjectInput, t_.uri)
+		}
+	}
+}
+
+func TestFetch_BucketPolicyMessage(t *testing.T) {
+	assert := assert.New(t)
+	cfg := apttransports3go.NewConfig()
+	cfg.AllowedBuckets = []string{"my-repo"}
+	cfg.DeniedBuckets = []string{"*/private/"}
+	ctx := log.Logger.WithContext(context.Background())
+
+	var buf strings.Builder
+	apttransports3go.Fetch(ctx, &buf, &MockS3API{}, cfg, map[string][]string{ //nolint:errcheck
+		"URI":      {"s3://other-repo/key"},
+		"Filename": {"/tmp/key"},
+	})
+
+	assert.Equal(`400 URI Failure
+FailReason: PolicyDenied
+Message: s3://other-repo/key is not allowed by Acquire::s3::AllowedBuckets
+URI: s3://other-repo/key
+
+`, buf.String())
+
+	buf.Reset()
+	apttransports3go.Fetch(ctx, &buf, &MockS3API{}, cfg, map[string][]string{ //nolint:errcheck
+		"URI":      {"s3://my-repo/private/key"},
+		"Filename": {"/tmp/key"},
+	})
+
+	assert.Equal(`400 URI Failure
+FailReason: PolicyDenied
+Message: s3://my-repo/private/key is denied by Acquire::s3::DeniedBuckets (*/private/)
+URI: s3://my-repo/private/key
+
+`, buf.String())
+}
+
+func TestDownload_BucketPolicy(t *testing.T) {
+	assert := assert.New(t)
+	cfg := apttransports3go.NewConfig()
+	cfg.AllowedBuckets = []string{"my-repo"}
+	ctx := log.Logger.WithContext(context.Background())
+	api := &MockS3API{
+		Body:          io.NopCloser(strings.NewReader("body")),
+		ContentLength: 4,
+	}
+
+	var buf strings.Builder
+	err := apttransports3go.Download(ctx, &buf, api, cfg, "s3://other-repo/key")
+
+	assert.EqualError(err, "s3://other-repo/key is not allowed by Acquire::s3::AllowedBuckets")
+	assert.Nil(api.GetObjectInput)
+	assert.Empty(buf.String())
+}
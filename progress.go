@@ -0,0 +1,60 @@
+package apttransports3go
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const progressInterval = 1 * time.Second
+
+// progressWriter reports the progress of a download with "102 Status".
+// Parts of a multipart download write to it concurrently.
+type progressWriter struct {
+	mu       sync.Mutex
+	ctx      context.Context
+	w        io.Writer
+	uri      string
+	size     int64
+	written  int64
+	interval time.Duration
+	last     time.Time
+}
+
+func newProgressWriter(ctx context.Context, w io.Writer, uri string, size int64, written int64, interval time.Duration) *progressWriter {
+	return &progressWriter{
+		ctx:      ctx,
+		w:        w,
+		uri:      uri,
+		size:     size,
+		written:  written,
+		interval: interval,
+		last:     time.Now(),
+	}
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	pw.written += int64(len(p))
+
+	if now := time.Now(); now.Sub(pw.last) >= pw.interval {
+		pw.last = now
+		send(pw.ctx, pw.w, StatusStatus, map[string]string{
+			"URI":     pw.uri,
+			"Message": pw.message(),
+		})
+	}
+
+	return len(p), nil
+}
+
+func (pw *progressWriter) message() string {
+	if pw.size <= 0 {
+		return fmt.Sprintf("Downloading %s", formatSize(pw.written))
+	}
+
+	return fmt.Sprintf("Downloading %s of %s (%d%%)", formatSize(pw.written), formatSize(pw.size), pw.written*100/pw.size)
+}
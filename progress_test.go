@@ -0,0 +1,62 @@
+package apttransports3go_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	apttransports3go "github.com/winebarrel/apt-transport-s3-go"
+)
+
+func TestProgressWriter(t *testing.T) {
+	assert := assert.New(t)
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	pw := apttransports3go.NewProgressWriter(ctx, &buf, "s3://example.com/key", 4096, 1024, 0)
+
+	n, err := io.WriteString(pw, strings.Repeat("x", 1024))
+	assert.NoError(err)
+	assert.Equal(1024, n)
+	io.WriteString(pw, strings.Repeat("x", 2048)) //nolint:errcheck
+
+	assert.Equal(`102 Status
+Message: Downloading 2.0 KiB of 4.0 KiB (50%)
+URI: s3://example.com/key
+
+102 Status
+Message: Downloading 4.0 KiB of 4.0 KiB (100%)
+URI: s3://example.com/key
+
+`, buf.String())
+}
+
+func TestProgressWriter_UnknownSize(t *testing.T) {
+	assert := assert.New(t)
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	pw := apttransports3go.NewProgressWriter(ctx, &buf, "s3://example.com/key", 0, 0, 0)
+	io.WriteString(pw, "apt body") //nolint:errcheck
+
+	assert.Equal(`102 Status
+Message: Downloading 8 B
+URI: s3://example.com/key
+
+`, buf.String())
+}
+
+func TestProgressWriter_Throttle(t *testing.T) {
+	assert := assert.New(t)
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	pw := apttransports3go.NewProgressWriter(ctx, &buf, "s3://example.com/key", 4096, 0, time.Hour)
+
+	for range 4 {
+		io.WriteString(pw, strings.Repeat("x", 1024)) //nolint:errcheck
+	}
+
+	assert.Empty(buf.String())
+}
@@ -0,0 +1,61 @@
+package apttransports3go
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+const sseCustomerKeySize = 32
+
+// secret hides its value when it is printed or logged.
+type secret string
+
+func (s secret) String() string {
+	if s == "" {
+		return ""
+	}
+
+	return "********"
+}
+
+func (s secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// readSSECustomerKey reads a 256-bit key for SSE-C as raw bytes or base64.
+func readSSECustomerKey(path string) (secret, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(data) == sseCustomerKeySize {
+		return secret(data), nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+
+	if err != nil || len(key) != sseCustomerKeySize {
+		// do not show the content of the file
+		return "", fmt.Errorf("key must be 256 bits in raw bytes or base64: %s", path)
+	}
+
+	return secret(key), nil
+}
+
+// sseCustomerKey returns the SSE-C parameters, or nils if the bucket has no key.
+func (bc *BucketConfig) sseCustomerKey() (*string, *string, *string) {
+	if bc.SSECustomerKey == "" {
+		return nil, nil, nil
+	}
+
+	key := []byte(bc.SSECustomerKey)
+	sum := md5.Sum(key)
+	return aws.String("AES256"), aws.String(base64.StdEncoding.EncodeToString(key)), aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+}
@@ -0,0 +1,159 @@
+package apttransports3go_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	apttransports3go "github.com/winebarrel/apt-transport-s3-go"
+)
+
+const (
+	testSSECustomerKey       = "0123456789abcdef0123456789abcdef"
+	testSSECustomerKeyBase64 = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+	testSSECustomerKeyMD5    = "hRasmdxgYDKV3nvbahU1MA=="
+)
+
+func configureSSECustomerKey(t *testing.T, content string) (*apttransports3go.Config, error) {
+	keyFile := filepath.Join(t.TempDir(), "sse-c.key")
+	os.WriteFile(keyFile, []byte(content), 0600) //nolint:errcheck
+	ctx := log.Logger.WithContext(context.Background())
+	return apttransports3go.Configure(ctx, map[string][]string{
+		"Config-Item": {"Acquire::s3::SSECustomerKeyFile::my-bucket=" + keyFile},
+	})
+}
+
+func TestConfigure_SSECustomerKeyFile(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, content := range []string{testSSECustomerKey, testSSECustomerKeyBase64 + "\n"} {
+		cfg, err := configureSSECustomerKey(t, content)
+		assert.NoError(err)
+		assert.Equal(testSSECustomerKey, string(cfg.Bucket("my-bucket").SSECustomerKey))
+		assert.Empty(cfg.Global.SSECustomerKey)
+		// the key is hidden when printed
+		assert.NotContains(fmt.Sprintf("%v %+v", cfg.Bucket("my-bucket"), *cfg.Bucket("my-bucket")), testSSECustomerKey)
+	}
+}
+
+func TestConfigure_BadSSECustomerKeyFile(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := configureSSECustomerKey(t, "short key")
+	assert.ErrorContains(err, "bad SSECustomerKeyFile: key must be 256 bits in raw bytes or base64: ")
+	assert.NotContains(err.Error(), "short key")
+
+	ctx := log.Logger.WithContext(context.Background())
+	_, err = apttransports3go.Configure(ctx, map[string][]string{
+		"Config-Item": {"Acquire::s3::SSECustomerKeyFile=/nonexistent"},
+	})
+	assert.EqualError(err, "bad SSECustomerKeyFile: open /nonexistent: no such file or directory")
+}
+
+func TestFetch_SSECustomerKey(t *testing.T) {
+	assert := assert.New(t)
+	cfg, err := configureSSECustomerKey(t, testSSECustomerKey)
+	assert.NoError(err)
+	dl := filepath.Join(t.TempDir(), "key")
+	// HEAD is made only for a partial download
+	os.WriteFile(dl+".s3partial", []byte("apt "), 0644) //nolint:errcheck
+	header := map[string][]string{
+		"URI":      {"s3://my-bucket/key"},
+		"Filename": {dl},
+	}
+
+	// the key must not be logged even in debug logs
+	defer zerolog.SetGlobalLevel(zerolog.GlobalLevel())
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	var logs strings.Builder
+	ctx := zerolog.New(&logs).WithContext(context.Background())
+	var buf strings.Builder
+	api := &MockS3API{
+		Body:          io.NopCloser(strings.NewReader("apt body")),
+		ContentLength: 8,
+	}
+	err = apttransports3go.Fetch(ctx, &buf, api, cfg, header)
+
+	assert.NoError(err)
+	assert.Contains(buf.String(), "201 URI Done\n")
+	assert.Equal("AES256", *api.HeadObjectInput.SSECustomerAlgorithm)
+	assert.Equal(testSSECustomerKeyBase64, *api.HeadObjectInput.SSECustomerKey)
+	assert.Equal(testSSECustomerKeyMD5, *api.HeadObjectInput.SSECustomerKeyMD5)
+	assert.Equal("AES256", *api.GetObjectInput.SSECustomerAlgorithm)
+	assert.Equal(testSSECustomerKeyBase64, *api.GetObjectInput.SSECustomerKey)
+	assert.Equal(testSSECustomerKeyMD5, *api.GetObjectInput.SSECustomerKeyMD5)
+	assert.NotEmpty(logs.String())
+	assert.NotContains(logs.String(), testSSECustomerKey)
+	assert.NotContains(logs.String(), testSSECustomerKeyBase64)
+	assert.NotContains(buf.String(), testSSECustomerKeyBase64)
+}
+
+func TestFetch_WithoutSSECustomerKey(t *testing.T) {
+	assert := assert.New(t)
+	cfg, err := configureSSECustomerKey(t, testSSECustomerKey)
+	assert.NoError(err)
+	header := map[string][]string{
+		"URI":      {"s3://other-bucket/key"},
+		"Filename": {filepath.Join(t.TempDir(), "key")},
+	}
+
+	ctx := log.Logger.WithContext(context.Background())
+	api := &MockS3API{
+		Body:          io.NopCloser(strings.NewReader("apt body")),
+		ContentLength: 8,
+	}
+	err = apttransports3go.Fetch(ctx, io.Discard, api, cfg, header)
+
+	assert.NoError(err)
+	assert.Nil(api.GetObjectInput.SSECustomerAlgorithm)
+	assert.Nil(api.GetObjectInput.SSECustomerKey)
+	assert.Nil(api.GetObjectInput.SSECustomerKeyMD5)
+}
+
+func TestDownload_SSECustomerKey(t *testing.T) {
+	assert := assert.New(t)
+	cfg, err := configureSSECustomerKey(t, testSSECustomerKeyBase64)
+	assert.NoError(err)
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	api := &MockS3API{
+		Body:          io.NopCloser(strings.NewReader("body")),
+		ContentLength: 4,
+	}
+	err = apttransports3go.Download(ctx, &buf, api, cfg, "s3://my-bucket/key")
+
+	assert.NoError(err)
+	assert.Equal("AES256", *api.GetObjectInput.SSECustomerAlgorithm)
+	assert.Equal(testSSECustomerKeyBase64, *api.GetObjectInput.SSECustomerKey)
+	assert.Equal(testSSECustomerKeyMD5, *api.GetObjectInput.SSECustomerKeyMD5)
+}
+
+func TestClient_SSECustomerKey(t *testing.T) {
+	assert := assert.New(t)
+	setAWSEnv(t)
+	cfg, err := configureSSECustomerKey(t, testSSECustomerKey)
+	assert.NoError(err)
+	httpClient := &recordingHTTPClient{body: "apt body"}
+	cfg.HTTPClient = httpClient
+	header := map[string][]string{
+		"URI":      {"s3://my-bucket/key"},
+		"Filename": {filepath.Join(t.TempDir(), "key")},
+	}
+
+	ctx := log.Logger.WithContext(context.Background())
+	err = apttransports3go.Fetch(ctx, io.Discard, apttransports3go.NewClient(cfg), cfg, header)
+
+	assert.NoError(err)
+	req := httpClient.reqs[0]
+	assert.Equal("AES256", req.Header.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm"))
+	assert.Equal(testSSECustomerKeyBase64, req.Header.Get("X-Amz-Server-Side-Encryption-Customer-Key"))
+	assert.Equal(testSSECustomerKeyMD5, req.Header.Get("X-Amz-Server-Side-Encryption-Customer-Key-Md5"))
+}
@@ -7,68 +7,124 @@ import (
 	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
-	"net/http"
-	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/rs/zerolog"
-	"github.com/rs/zerolog/log"
 )
 
-func init() {
-	logLevelStr := os.Getenv("ATS3_LOG_LEVEL")
-	zerolog.SetGlobalLevel(zerolog.InfoLevel)
-
-	if logLevelStr != "" {
-		logLevel, err := zerolog.ParseLevel(logLevelStr)
-
-		if err != nil {
-			log.Warn().Err(err).Send()
-		} else {
-			zerolog.SetGlobalLevel(logLevel)
-		}
-	}
+type message struct {
+	code   Status
+	status string
+	header map[string][]string
+	err    error
 }
 
 func Run(ctx context.Context, r io.Reader, w io.Writer) error {
-	logger := zerolog.Ctx(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// messages from concurrent fetches must not interleave
+	w = &syncWriter{w: w}
+	// apt often hides stderr of the method, so the logs are also sent as "101 Log".
+	// The caller's logger keeps its output and level; Debug::Acquire::s3 only changes the level of "101 Log".
+	callerLogger := zerolog.Ctx(ctx)
+	logw := newLogWriter(w)
+	logger := zerolog.New(zerolog.MultiLevelWriter(&loggerWriter{logger: callerLogger}, logw)).
+		Level(min(callerLogger.GetLevel(), zerolog.DebugLevel))
+	ctx = logger.WithContext(ctx)
 	SendCapabilities(ctx, w)
 	logger.Debug().Msg("start main loop")
 	defer logger.Debug().Msg("finish main loop by")
-	bufReader := bufio.NewReader(r)
-	var cfg aws.Config
+	msgs := make(chan *message)
+	go receive(ctx, bufio.NewReader(r), msgs)
+	cfg := NewConfig()
+	auth := newAuthorizer(w)
+	var client *Client
+	var sem chan struct{}
+	var wg sync.WaitGroup
+	errs := make(chan error, 1)
 
 	for {
 		logger.Debug().Msg("start process")
-		code, status, header, err := read(ctx, bufReader)
-		logger := logger.With().Int("code", int(code)).Str("status", status).Logger()
+		var msg *message
+
+		select {
+		case err := <-errs:
+			return err
+		case msg = <-msgs:
+		}
+
+		logger := logger.With().Int("code", int(msg.code)).Str("status", msg.status).Logger()
 		logger.Debug().Msg("receive message")
 
-		if err != nil {
-			if err == io.EOF {
-				return nil
+		if msg.err != nil {
+			if msg.err == io.EOF {
+				// credentials will no longer come
+				auth.close()
+				wg.Wait()
+
+				select {
+				case err := <-errs:
+					return err
+				default:
+					return nil
+				}
 			} else {
-				return err
+				return msg.err
 			}
 		}
 
-		switch code {
+		var err error
+
+		switch msg.code {
 		case StatusConfiguration:
-			cfg, err = Configure(ctx, header)
+			cfg, err = Configure(ctx, msg.header)
+			client = nil
+			sem = nil
+
+			if err == nil && cfg.Debug {
+				logw.setLevel(zerolog.DebugLevel)
+			} else if err == nil {
+				logw.setLevel(zerolog.WarnLevel)
+			}
 		case StatusURIAcquire:
-			client := s3.NewFromConfig(cfg)
-			err = Fetch(ctx, w, client, header)
+			if client == nil {
+				client = NewClient(cfg)
+				client.authorize = auth.authorize
+				sem = make(chan struct{}, cfg.MaxParallel)
+			}
+
+			wg.Add(1)
+			go func(client *Client, cfg *Config, sem chan struct{}, header map[string][]string) {
+				defer wg.Done()
+
+				select {
+				case sem <- struct{}{}:
+					defer func() { <-sem }()
+				case <-ctx.Done():
+					return
+				}
+
+				if err := Fetch(ctx, w, client, cfg, header); err != nil {
+					select {
+					case errs <- err:
+					default:
+					}
+				}
+			}(client, cfg, sem, msg.header)
+		case StatusAuthorizationCredentials:
+			auth.receive(ctx, msg.header)
 		default:
-			err = fmt.Errorf("not implemented: %d %s", code, status)
+			err = fmt.Errorf("not implemented: %d %s", msg.code, msg.status)
 		}
 
 		if err != nil {
@@ -79,6 +135,22 @@ func Run(ctx context.Context, r io.Reader, w io.Writer) error {
 	}
 }
 
+func receive(ctx context.Context, r *bufio.Reader, msgs chan<- *message) {
+	for {
+		code, status, header, err := read(ctx, r)
+
+		select {
+		case msgs <- &message{code: code, status: status, header: header, err: err}:
+		case <-ctx.Done():
+			return
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
 func SendCapabilities(ctx context.Context, w io.Writer) {
 	logger := zerolog.Ctx(ctx)
 	logger.Debug().Msg("set capabilities")
@@ -86,175 +158,445 @@ func SendCapabilities(ctx context.Context, w io.Writer) {
 	send(ctx, w, StatusCapabilities, map[string]string{
 		"Version":         "1.1",
 		"Single-Instance": "true",
+		"Pipeline":        "true",
 		"Send-Config":     "true",
+		// apt sends URIs without decoding them so that keys are decoded exactly once
+		"Send-URI-Encoded": "true",
 	})
 }
 
-func Configure(ctx context.Context, header map[string][]string) (aws.Config, error) {
-	logger := zerolog.Ctx(ctx)
-	logger.Debug().Msg("start configure")
-	defer logger.Debug().Msg("finish configure")
-	cfgItems, ok := header["Config-Item"]
+func Fetch(ctx context.Context, w io.Writer, api S3API, cfg *Config, header map[string][]string) error {
+	uriStr := header["URI"][0]
+	logger := zerolog.Ctx(ctx).With().Str("uri", uriStr).Logger()
+	logger.Debug().Msg("start fetch")
+	uri, err := parseS3URI(uriStr)
+
+	// a bad URI fails only the fetch, not the other pipelined ones
+	if err != nil {
+		logger.Warn().Err(err).Msg("bad URI")
+		sendFailure(ctx, w, uriStr, &uriFailure{err: fmt.Errorf("bad URI: %w", err), reason: "BadURI"})
+		return nil
+	}
 
-	if !ok {
-		return config.LoadDefaultConfig(ctx)
+	// no request is sent to a bucket out of the policy
+	if err := cfg.checkPolicy(uri.bucket, uri.key); err != nil {
+		logger.Warn().Err(err).Msg("deny fetch")
+		sendFailure(ctx, w, uriStr, &uriFailure{err: err, reason: "PolicyDenied"})
+		return nil
 	}
 
-	optFuns := []func(*config.LoadOptions) error{}
+	send(ctx, w, StatusStatus, map[string]string{"URI": uriStr, "Message": "Waiting for headers"})
+
+	req := &fetchRequest{
+		uri:    uriStr,
+		bucket: uri.bucket,
+		key:    uri.key,
+		fn:     header["Filename"][0],
+		header: header,
+	}
 
-	for _, item := range cfgItems {
-		words := strings.SplitN(item, "=", 2)
+	// pin the object version with "?versionId=..."
+	if versionID := uri.query.Get("versionId"); versionID != "" {
+		req.versionID = aws.String(versionID)
+	}
 
-		if len(words) < 2 {
-			return aws.Config{}, fmt.Errorf("bad config item: %s", item)
+	if lastModified, ok := header["Last-Modified"]; ok {
+		ims, err := time.Parse(time.RFC1123, lastModified[0])
+
+		if err != nil {
+			logger.Warn().Err(err).Msg("ignore bad Last-Modified")
+		} else {
+			req.ifModifiedSince = aws.Time(ims.UTC())
 		}
+	}
 
-		key := words[0]
-		value := words[1]
+	if maximumSize, ok := header["Maximum-Size"]; ok {
+		n, err := strconv.ParseInt(maximumSize[0], 10, 64)
 
-		switch key {
-		case "Acquire::http::Proxy":
-			proxyURL, err := url.Parse(value)
+		if err != nil {
+			logger.Warn().Err(err).Msg("ignore bad Maximum-Size")
+		} else {
+			req.maximumSize = n
+		}
+	}
+
+	logger = logger.With().Str("bucket", req.bucket).Str("key", req.key).Logger()
+	ctx = logger.WithContext(ctx)
+
+	bc := cfg.Bucket(req.bucket)
+
+	for attempt := 0; ; attempt++ {
+		err := fetchObject(ctx, w, api, bc, req)
+		var failure *uriFailure
 
-			if err != nil {
-				return aws.Config{}, fmt.Errorf("bad proxy URL: %w: %s", err, value)
+		if !errors.As(err, &failure) {
+			if err == nil {
+				logger.Debug().Msg("finish fetch")
 			}
 
-			httpClient := awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
-				tr.Proxy = http.ProxyURL(proxyURL)
-			})
+			return err
+		}
 
-			optFuns = append(optFuns, config.WithHTTPClient(httpClient))
-		case "Acquire::s3::region":
-			optFuns = append(optFuns, config.WithRegion(value))
-		default:
-			continue
+		if !failure.transient || attempt >= cfg.Retries {
+			// apt would retry a transient failure up to Acquire::Retries times again
+			if attempt > 0 {
+				failure.transient = false
+			}
+
+			sendFailure(ctx, w, uriStr, explainForbidden(ctx, api, uri.bucket, failure, bc))
+			return nil
 		}
 
-		logger.Debug().Str(key, value).Msg("configure")
-	}
+		delay := cfg.retryDelay(attempt)
+		logger.Warn().Err(failure).Int("attempt", attempt+1).Dur("delay", delay).Msg("retry fetch")
 
-	return config.LoadDefaultConfig(ctx, optFuns...)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
 }
 
-type S3API interface {
-	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
-	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+type fetchRequest struct {
+	uri             string
+	bucket          string
+	key             string
+	versionID       *string
+	fn              string
+	header          map[string][]string
+	ifModifiedSince *time.Time
+	maximumSize     int64
 }
 
-func Fetch(ctx context.Context, w io.Writer, api S3API, header map[string][]string) error {
-	uriStr := header["URI"][0]
-	logger := zerolog.Ctx(ctx).With().Str("uri", uriStr).Logger()
-	logger.Debug().Msg("start fetch")
-	uri, err := url.Parse(uriStr)
+func fetchObject(ctx context.Context, w io.Writer, api S3API, bc *BucketConfig, req *fetchRequest) error {
+	logger := zerolog.Ctx(ctx)
+	getObjInput := &s3.GetObjectInput{
+		Bucket:          aws.String(req.bucket),
+		Key:             aws.String(req.key),
+		VersionId:       req.versionID,
+		IfModifiedSince: req.ifModifiedSince,
+	}
 
-	if err != nil {
-		return fmt.Errorf("bad URI: %w: %s", err, uriStr)
+	applyRequestOptions(bc, getObjInput)
+
+	var resumeFrom int64
+	var cached string
+	var objHead *s3.HeadObjectOutput
+	fi, err := os.Stat(partialPath(req.fn))
+	hasPartial := err == nil && fi.Size() > 0
+
+	headObjInput := &s3.HeadObjectInput{
+		Bucket:          aws.String(req.bucket),
+		Key:             aws.String(req.key),
+		VersionId:       req.versionID,
+		IfModifiedSince: req.ifModifiedSince,
 	}
 
-	send(ctx, w, StatusStatus, map[string]string{"URI": uriStr, "Message": "Waiting for headers"})
+	applyRequestOptions(bc, headObjInput)
 
-	bucket := uri.Host
-	key := strings.TrimPrefix(uri.Path, "/")
+	// HEAD is only needed to look up the cache or to know whether the partial download can be resumed
+	if bc.CacheDir != "" || hasPartial {
+		logger.Debug().Msg("head object")
+		objHead, err = api.HeadObject(ctx, headObjInput)
 
-	logger = logger.With().Str("bucket", bucket).Str("key", key).Logger()
-	logger.Debug().Msg("head object")
-	objHead, err := api.HeadObject(ctx, &s3.HeadObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-	})
+		if isNotModified(err) {
+			sendIMSHit(ctx, w, req)
+			return nil
+		} else if err != nil {
+			return newURIFailure(err)
+		}
 
-	if err != nil {
-		send(ctx, w, StatusURIFailure, map[string]string{"URI": uriStr, "Message": err.Error()})
-		return nil
+		if err := checkMaximumSize(req, aws.ToInt64(objHead.ContentLength)); err != nil {
+			return err
+		}
+
+		if bc.CacheDir != "" && aws.ToString(objHead.ETag) != "" {
+			cached = cachePath(bc.CacheDir, req.bucket, req.key, aws.ToString(objHead.ETag))
+
+			if fetchCache(ctx, w, req, cached, objHead) {
+				return nil
+			}
+		}
+
+		if hasPartial {
+			resumeFrom = resumePoint(req.fn, aws.ToInt64(objHead.ContentLength), aws.ToString(objHead.ETag), aws.ToTime(objHead.LastModified))
+		}
+
+		if resumeFrom > 0 {
+			logger.Debug().Int64("resume_point", resumeFrom).Msg("resume download")
+		}
+
+		// make sure the object has not changed since HEAD
+		getObjInput.IfMatch = objHead.ETag
 	}
 
-	send(ctx, w, StatusURIStart, map[string]string{
-		"URI":           uriStr,
-		"Size":          strconv.FormatInt(aws.ToInt64(objHead.ContentLength), 10),
-		"Last-Modified": objHead.LastModified.UTC().Format(time.RFC1123),
-	})
+	// the size is known from HEAD or expected by apt
+	if objHead != nil {
+		getObjInput.Range = firstRange(resumeFrom, aws.ToInt64(objHead.ContentLength), bc)
+	} else {
+		getObjInput.Range = firstRange(resumeFrom, req.maximumSize, bc)
+	}
 
 	logger.Debug().Msg("get object")
-	obj, err := api.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-	})
+	obj, err := api.GetObject(ctx, getObjInput)
 
-	if err != nil {
-		send(ctx, w, StatusURIFailure, map[string]string{"URI": uriStr, "Message": err.Error()})
+	if isNotModified(err) {
+		sendIMSHit(ctx, w, req)
 		return nil
+	} else if err != nil {
+		return newURIFailure(err)
 	}
 
 	defer obj.Body.Close()
+	size := objectSize(obj)
+
+	// reject the object before downloading it
+	if err := checkMaximumSize(req, size); err != nil {
+		return err
+	}
+
+	// a ranged GET does not return the checksum of the whole object
+	wholeChecksum := getObjInput.Range != nil
+
+	if wholeChecksum && objHead == nil {
+		logger.Debug().Msg("head object for checksum")
+		headObjInput.IfMatch = obj.ETag
+		objHead, err = api.HeadObject(ctx, headObjInput)
+
+		if err != nil {
+			return newURIFailure(err)
+		}
+	}
+
+	lastModified := aws.ToTime(obj.LastModified)
+	startHeader := map[string]string{
+		"URI":           req.uri,
+		"Size":          strconv.FormatInt(size, 10),
+		"Last-Modified": lastModified.UTC().Format(time.RFC1123),
+	}
+
+	if resumeFrom > 0 {
+		startHeader["Resume-Point"] = strconv.FormatInt(resumeFrom, 10)
+	}
 
-	fn := header["Filename"][0]
-	logger.Debug().Str("filename", fn).Msg("create file")
-	fp, err := os.OpenFile(fn, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	send(ctx, w, StatusURIStart, startHeader)
+
+	fn := req.fn
+	logger.Debug().Str("filename", partialPath(fn)).Msg("open partial file")
+	fp, err := openPartialFile(fn, resumeFrom, aws.ToString(obj.ETag), lastModified)
 
 	if err != nil {
-		return fmt.Errorf("failed to open file: %w: %s", err, fn)
+		return fmt.Errorf("failed to create file: %w: %s", err, partialPath(fn))
+	}
+
+	// an interrupted download is kept to be resumed
+	defer fp.close()
+
+	hs := newHashSums()
+	var sums io.Writer = hs
+	checksum := getObjectChecksum(obj, hs)
+
+	if wholeChecksum {
+		checksum = headObjectChecksum(objHead, hs)
+	}
+
+	if checksum != nil {
+		sums = io.MultiWriter(hs, checksum)
+	}
+
+	if resumeFrom > 0 {
+		// re-hash the partial download before appending to it
+		if _, err := io.CopyN(sums, fp, resumeFrom); err != nil {
+			return fmt.Errorf("failed to read partial file: %w: %s", err, partialPath(fn))
+		}
+	}
+
+	// apt cannot see the size of the temporary file, so report the progress explicitly
+	progress := newProgressWriter(ctx, w, req.uri, size, resumeFrom, progressInterval)
+	written, copyErr := writeObject(ctx, fp.File, sums, progress, api, getObjInput, obj, resumeFrom, bc)
+
+	// the SDK fails at the end of the body if the checksum does not match,
+	// which is reported as a hash sum mismatch below
+	if copyErr != nil && written < size-resumeFrom {
+		return newURIFailure(copyErr)
 	}
 
-	defer fp.Close()
+	err = verifyHashes(req.header, hs.sums())
 
-	hmd5 := md5.New()
-	hs256 := sha256.New()
-	hs512 := sha512.New()
-	fw := io.MultiWriter(fp, hmd5, hs256, hs512)
-	_, err = io.Copy(fw, obj.Body)
+	if err == nil && checksum != nil {
+		err = checksum.verify()
+	}
 
 	if err != nil {
-		send(ctx, w, StatusURIFailure, map[string]string{"URI": uriStr, "Message": err.Error()})
-		return nil
+		// the partial download may be corrupted
+		logger.Debug().Err(err).Str("filename", fn).Msg("remove file")
+		fp.discard()
+		os.Remove(fn)
+		return &uriFailure{err: err, reason: "HashSumMismatch"}
+	}
+
+	if copyErr != nil {
+		return newURIFailure(copyErr)
+	}
+
+	// keep the object's timestamp on the file as apt's http method does
+	if err := fp.commit(lastModified); err != nil {
+		return &uriFailure{err: fmt.Errorf("failed to write file: %w: %s", err, fn)}
 	}
 
-	hmd5Sum := hmd5.Sum(nil)
+	if cached != "" {
+		storeCache(ctx, fn, cached, lastModified)
+	}
+
+	sendDone(ctx, w, req, size, lastModified, hs)
+	return nil
+}
 
+func checkMaximumSize(req *fetchRequest, size int64) error {
+	if req.maximumSize > 0 && size > req.maximumSize {
+		return &uriFailure{
+			err:    fmt.Errorf("file is larger than expected (%d > %d)", size, req.maximumSize),
+			reason: "MaximumSizeExceeded",
+		}
+	}
+
+	return nil
+}
+
+type hashSums struct {
+	md5    hash.Hash
+	sha256 hash.Hash
+	sha512 hash.Hash
+}
+
+func newHashSums() *hashSums {
+	return &hashSums{md5: md5.New(), sha256: sha256.New(), sha512: sha512.New()}
+}
+
+func (hs *hashSums) Write(p []byte) (int, error) {
+	hs.md5.Write(p)
+	hs.sha256.Write(p)
+	hs.sha512.Write(p)
+	return len(p), nil
+}
+
+func (hs *hashSums) sums() map[string]string {
+	return map[string]string{
+		"MD5Sum": hex.EncodeToString(hs.md5.Sum(nil)),
+		"SHA256": hex.EncodeToString(hs.sha256.Sum(nil)),
+		"SHA512": hex.EncodeToString(hs.sha512.Sum(nil)),
+	}
+}
+
+func verifyHashes(header map[string][]string, sums map[string]string) error {
+	// check the strongest hash first
+	for _, name := range []string{"SHA512", "SHA256", "MD5Sum"} {
+		expected, ok := header["Expected-"+name]
+
+		if !ok {
+			continue
+		}
+
+		if !strings.EqualFold(expected[0], sums[name]) {
+			return fmt.Errorf("hash sum mismatch: %s expected %s, but got %s", name, expected[0], sums[name])
+		}
+	}
+
+	return nil
+}
+
+func sendDone(ctx context.Context, w io.Writer, req *fetchRequest, size int64, lastModified time.Time, hs *hashSums) {
+	sums := hs.sums()
 	send(ctx, w, StatusURIDone, map[string]string{
-		"URI":           uriStr,
-		"Filename":      fn,
-		"Size":          strconv.FormatInt(aws.ToInt64(objHead.ContentLength), 10),
-		"Last-Modified": objHead.LastModified.UTC().Format(time.RFC1123),
-		"MD5-Hash":      hex.EncodeToString(hmd5Sum),
-		"MD5Sum-Hash":   hex.EncodeToString(hmd5Sum),
-		"SHA256-Hash":   hex.EncodeToString(hs256.Sum(nil)),
-		"SHA512-Hash":   hex.EncodeToString(hs512.Sum(nil)),
+		"URI":           req.uri,
+		"Filename":      req.fn,
+		"Size":          strconv.FormatInt(size, 10),
+		"Last-Modified": lastModified.UTC().Format(time.RFC1123),
+		"MD5-Hash":      sums["MD5Sum"],
+		"MD5Sum-Hash":   sums["MD5Sum"],
+		"SHA256-Hash":   sums["SHA256"],
+		"SHA512-Hash":   sums["SHA512"],
 	})
+}
 
-	logger.Debug().Msg("finish fetch")
-	return nil
+func sendIMSHit(ctx context.Context, w io.Writer, req *fetchRequest) {
+	send(ctx, w, StatusURIDone, map[string]string{
+		"URI":           req.uri,
+		"Filename":      req.fn,
+		"Last-Modified": req.header["Last-Modified"][0],
+		"IMS-Hit":       "true",
+	})
 }
 
-func Download(ctx context.Context, w io.Writer, api S3API, uriStr string) error {
+func sendFailure(ctx context.Context, w io.Writer, uriStr string, failure *uriFailure) {
+	header := map[string]string{
+		"URI":     uriStr,
+		"Message": failure.Error(),
+	}
+
+	if failure.reason != "" {
+		header["FailReason"] = failure.reason
+	}
+
+	if failure.transient {
+		header["Transient-Failure"] = "true"
+	}
+
+	send(ctx, w, StatusURIFailure, header)
+}
+
+func Download(ctx context.Context, w io.Writer, api S3API, cfg *Config, uriStr string) error {
 	logger := zerolog.Ctx(ctx).With().Str("uri", uriStr).Logger()
 	logger.Debug().Msg("start download")
-	uri, err := url.Parse(uriStr)
+	uri, err := parseS3URI(uriStr)
 
 	if err != nil {
 		return fmt.Errorf("bad URI: %w: %s", err, uriStr)
 	}
 
-	bucket := uri.Host
-	key := strings.TrimPrefix(uri.Path, "/")
+	if err := cfg.checkPolicy(uri.bucket, uri.key); err != nil {
+		return err
+	}
+
+	bc := cfg.Bucket(uri.bucket)
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(uri.bucket),
+		Key:    aws.String(uri.key),
+	}
+
+	applyRequestOptions(bc, input)
+
+	if versionID := uri.query.Get("versionId"); versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
 
 	logger.Debug().Msg("get object")
-	obj, err := api.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-	})
+	obj, err := api.GetObject(ctx, input)
 
 	if err != nil {
-		return fmt.Errorf("get object failed: %w: %s", err, uriStr)
+		return fmt.Errorf("get object failed: %w: %s", explainForbidden(ctx, api, uri.bucket, newURIFailure(err), bc), uriStr)
 	}
 
 	defer obj.Body.Close()
-	_, err = io.Copy(w, obj.Body)
+	checksum := getObjectChecksum(obj, nil)
+
+	if checksum != nil {
+		w = io.MultiWriter(w, checksum)
+	}
+
+	_, err = copyObject(ctx, w, api, input, obj, 0, bc)
 
 	if err != nil {
 		return fmt.Errorf("copy object failed: %w: %s", err, uriStr)
 	}
 
+	if checksum != nil {
+		if err := checksum.verify(); err != nil {
+			return fmt.Errorf("%w: %s", err, uriStr)
+		}
+	}
+
 	logger.Debug().Msg("finish download")
 	return nil
 }
@@ -2,10 +2,13 @@ package apttransports3go_test
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"strings"
 	"testing"
 
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/assert"
 	apttransports3go "github.com/winebarrel/apt-transport-s3-go"
@@ -17,8 +20,9 @@ func TestDownload_OK(t *testing.T) {
 	var buf strings.Builder
 	ctx := log.Logger.WithContext(context.Background())
 	err := apttransports3go.Download(ctx, &buf, &MockS3API{
-		Body: io.NopCloser(strings.NewReader("body")),
-	}, "s3://my-bucket/key")
+		Body:          io.NopCloser(strings.NewReader("body")),
+		ContentLength: 4,
+	}, apttransports3go.NewConfig(), "s3://my-bucket/key")
 
 	assert.NoError(err)
 	assert.Equal("body", buf.String())
@@ -31,7 +35,161 @@ func TestDownload_InvalidUIR(t *testing.T) {
 	ctx := log.Logger.WithContext(context.Background())
 	err := apttransports3go.Download(ctx, &buf, &MockS3API{
 		Body: io.NopCloser(strings.NewReader("body")),
-	}, ":")
+	}, apttransports3go.NewConfig(), ":")
 
 	assert.Error(err)
 }
+
+func TestDownload_Multipart(t *testing.T) {
+	assert := assert.New(t)
+	var content strings.Builder
+
+	for i := range 1000000 {
+		fmt.Fprintf(&content, "%d\n", i)
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	cfg := apttransports3go.NewConfig()
+	cfg.Global.PartSize = 1024 * 1024
+	cfg.Global.Concurrency = 3
+	api := &MockS3API{Content: content.String(), ETag: `"etag"`}
+	err := apttransports3go.Download(ctx, &buf, api, cfg, "s3://my-bucket/key")
+
+	assert.NoError(err)
+	assert.Equal(content.String(), buf.String())
+	// 6888890 bytes
+	assert.Len(api.GetObjectInputs, 7)
+	// the size is unknown, so the first GET is plain and only its first part is read
+	assert.Nil(api.GetObjectInputs[0].Range)
+	assert.Nil(api.HeadObjectInput)
+
+	for _, input := range api.GetObjectInputs[1:] {
+		assert.Regexp(`\Abytes=\d+-\d+\z`, *input.Range)
+		assert.Equal(`"etag"`, *input.IfMatch)
+	}
+}
+
+func TestDownload_WithoutHeadObject(t *testing.T) {
+	for name, content := range map[string]string{"small": "body", "empty": ""} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			var buf strings.Builder
+			ctx := log.Logger.WithContext(context.Background())
+			api := &MockS3API{
+				Body:           io.NopCloser(strings.NewReader(content)),
+				ContentLength:  len(content),
+				ChecksumCRC32C: "hVpiiA==",
+			}
+			err := apttransports3go.Download(ctx, &buf, api, apttransports3go.NewConfig(), "s3://my-bucket/key")
+
+			// the checksum of a plain GET is verified without HEAD
+			assert.ErrorContains(err, "checksum mismatch: ChecksumCRC32C expected hVpiiA==")
+			assert.Equal(content, buf.String())
+			assert.Nil(api.GetObjectInput.Range)
+			assert.Nil(api.HeadObjectInput)
+		})
+	}
+}
+
+func TestDownload_MultipartDisabled(t *testing.T) {
+	assert := assert.New(t)
+	content := strings.Repeat("x", 3*1024*1024)
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	cfg := apttransports3go.NewConfig()
+	cfg.Global.PartSize = 1024 * 1024
+	cfg.Global.Concurrency = 1
+	api := &MockS3API{Content: content}
+	err := apttransports3go.Download(ctx, &buf, api, cfg, "s3://my-bucket/key")
+
+	assert.NoError(err)
+	assert.Equal(content, buf.String())
+	assert.Len(api.GetObjectInputs, 1)
+}
+
+func TestDownload_ShortRead(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	err := apttransports3go.Download(ctx, &buf, &MockS3API{
+		Body:          io.NopCloser(strings.NewReader("bo")),
+		ContentLength: 4,
+	}, apttransports3go.NewConfig(), "s3://my-bucket/key")
+
+	assert.EqualError(err, "copy object failed: unexpected EOF: got 2 bytes, but expected 4 bytes: s3://my-bucket/key")
+}
+
+func TestDownload_VersionID(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	api := &MockS3API{
+		Body:          io.NopCloser(strings.NewReader("body")),
+		ContentLength: 4,
+	}
+	err := apttransports3go.Download(ctx, &buf, api, apttransports3go.NewConfig(), "s3://my-bucket/key?versionId=v1")
+
+	assert.NoError(err)
+	assert.Equal("body", buf.String())
+	assert.Equal("key", *api.GetObjectInput.Key)
+	assert.Equal("v1", *api.GetObjectInput.VersionId)
+}
+
+func TestDownload_RequesterPays(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	cfg := apttransports3go.NewConfig()
+	cfg.Buckets["my-bucket"] = &apttransports3go.BucketConfig{RequesterPays: true}
+	api := &MockS3API{
+		Body:          io.NopCloser(strings.NewReader("body")),
+		ContentLength: 4,
+	}
+	err := apttransports3go.Download(ctx, &buf, api, cfg, "s3://my-bucket/key")
+
+	assert.NoError(err)
+	assert.Equal(types.RequestPayerRequester, api.GetObjectInput.RequestPayer)
+}
+
+func TestDownload_RequesterPaysHint(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	err := apttransports3go.Download(ctx, &buf, &MockS3API{
+		GetObjectError: newResponseError(403, &smithy.GenericAPIError{Code: "AccessDenied", Message: "Access Denied"}),
+	}, apttransports3go.NewConfig(), "s3://my-bucket/key")
+
+	assert.ErrorContains(err, "(set Acquire::s3::RequesterPays if the bucket is Requester Pays): s3://my-bucket/key")
+}
+
+func TestDownload_ExpectedBucketOwner(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	cfg := apttransports3go.NewConfig()
+	cfg.Global.ExpectedBucketOwner = "123456789012"
+	api := &MockS3API{
+		Body:          io.NopCloser(strings.NewReader("body")),
+		ContentLength: 4,
+	}
+	err := apttransports3go.Download(ctx, &buf, api, cfg, "s3://my-bucket/key")
+
+	assert.NoError(err)
+	assert.Equal("123456789012", *api.GetObjectInput.ExpectedBucketOwner)
+
+	api.GetObjectError = newResponseError(403, &smithy.GenericAPIError{Code: "AccessDenied", Message: "Access Denied"})
+	err = apttransports3go.Download(ctx, &buf, api, cfg, "s3://my-bucket/key")
+	assert.ErrorContains(err, "(set Acquire::s3::RequesterPays if the bucket is Requester Pays): s3://my-bucket/key")
+
+	api.BucketOwner = "210987654321"
+	err = apttransports3go.Download(ctx, &buf, api, cfg, "s3://my-bucket/key")
+	assert.ErrorContains(err, "(the bucket is not owned by 123456789012): s3://my-bucket/key")
+}
@@ -5,11 +5,16 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/assert"
 	apttransports3go "github.com/winebarrel/apt-transport-s3-go"
@@ -28,9 +33,9 @@ func TestFetch_OK(t *testing.T) {
 	ctx := log.Logger.WithContext(context.Background())
 	apttransports3go.Fetch(ctx, &buf, &MockS3API{ //nolint:errcheck
 		Body:          io.NopCloser(strings.NewReader("apt body")),
-		ContentLength: 100,
+		ContentLength: 8,
 		LastModified:  timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00"),
-	}, header)
+	}, apttransports3go.NewConfig(), header)
 
 	assert.Equal(fmt.Sprintf(`102 Status
 Message: Waiting for headers
@@ -38,7 +43,7 @@ URI: s3://example.com/key
 
 200 URI Start
 Last-Modified: Sun, 20 Nov 2022 12:34:56 UTC
-Size: 100
+Size: 8
 URI: s3://example.com/key
 
 201 URI Done
@@ -48,7 +53,7 @@ MD5-Hash: 600c0724d390c99d2db510c260402a50
 MD5Sum-Hash: 600c0724d390c99d2db510c260402a50
 SHA256-Hash: 53ce64325a3802023c1922d1eda5a1d67c1183c31ba509277cfa6350d01cdd85
 SHA512-Hash: e62d8d35da15710e6940c5ed201ddcd1f3debb04879ddd95e091084880b17d3b6c879c019389bd3e49e697c0d58ad14f0358da41f0a9e304eab1319ff1b4e5e3
-Size: 100
+Size: 8
 URI: s3://example.com/key
 
 `, dl.Name()), buf.String())
@@ -58,6 +63,9 @@ func TestFetch_HeadObjectError(t *testing.T) {
 	assert := assert.New(t)
 	dl, _ := os.CreateTemp("", "")
 	defer os.Remove(dl.Name())
+	// HEAD is made only for a partial download
+	os.WriteFile(dl.Name()+".s3partial", []byte("apt "), 0644) //nolint:errcheck
+	defer os.Remove(dl.Name() + ".s3partial")
 	header := map[string][]string{
 		"URI":      {"s3://example.com/key"},
 		"Filename": {dl.Name()},
@@ -67,10 +75,10 @@ func TestFetch_HeadObjectError(t *testing.T) {
 	ctx := log.Logger.WithContext(context.Background())
 	apttransports3go.Fetch(ctx, &buf, &MockS3API{ //nolint:errcheck
 		Body:            io.NopCloser(strings.NewReader("apt body")),
-		ContentLength:   100,
+		ContentLength:   8,
 		LastModified:    timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00"),
 		HeadObjectError: errors.New("HeadObjectError"),
-	}, header)
+	}, apttransports3go.NewConfig(), header)
 
 	assert.Equal(`102 Status
 Message: Waiting for headers
@@ -96,23 +104,1166 @@ func TestFetch_GetObjectError(t *testing.T) {
 	ctx := log.Logger.WithContext(context.Background())
 	apttransports3go.Fetch(ctx, &buf, &MockS3API{ //nolint:errcheck
 		Body:           io.NopCloser(strings.NewReader("apt body")),
-		ContentLength:  100,
+		ContentLength:  8,
 		LastModified:   timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00"),
 		GetObjectError: errors.New("GetObjectError"),
-	}, header)
+	}, apttransports3go.NewConfig(), header)
 
 	assert.Equal(`102 Status
 Message: Waiting for headers
 URI: s3://example.com/key
 
+400 URI Failure
+Message: GetObjectError
+URI: s3://example.com/key
+
+`, buf.String())
+}
+
+func TestFetch_IMSHitOnHeadObject(t *testing.T) {
+	assert := assert.New(t)
+	dl, _ := os.CreateTemp("", "")
+	defer os.Remove(dl.Name())
+	os.WriteFile(dl.Name()+".s3partial", []byte("apt "), 0644) //nolint:errcheck
+	defer os.Remove(dl.Name() + ".s3partial")
+	header := map[string][]string{
+		"URI":           {"s3://example.com/key"},
+		"Filename":      {dl.Name()},
+		"Last-Modified": {"Sun, 20 Nov 2022 12:34:56 GMT"},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	api := &MockS3API{
+		Body:            io.NopCloser(strings.NewReader("apt body")),
+		ContentLength:   8,
+		LastModified:    timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00"),
+		HeadObjectError: newResponseError(304, errors.New("NotModified")),
+	}
+	apttransports3go.Fetch(ctx, &buf, api, apttransports3go.NewConfig(), header) //nolint:errcheck
+
+	assert.Equal(fmt.Sprintf(`102 Status
+Message: Waiting for headers
+URI: s3://example.com/key
+
+201 URI Done
+Filename: %s
+IMS-Hit: true
+Last-Modified: Sun, 20 Nov 2022 12:34:56 GMT
+URI: s3://example.com/key
+
+`, dl.Name()), buf.String())
+	assert.True(timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00").Equal(*api.HeadObjectInput.IfModifiedSince))
+	assert.Nil(api.GetObjectInput)
+}
+
+func TestFetch_IMSHitOnGetObject(t *testing.T) {
+	assert := assert.New(t)
+	dl, _ := os.CreateTemp("", "")
+	defer os.Remove(dl.Name())
+	header := map[string][]string{
+		"URI":           {"s3://example.com/key"},
+		"Filename":      {dl.Name()},
+		"Last-Modified": {"Sun, 20 Nov 2022 12:34:56 GMT"},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	api := &MockS3API{
+		Body:           io.NopCloser(strings.NewReader("apt body")),
+		ContentLength:  8,
+		LastModified:   timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00"),
+		GetObjectError: newResponseError(304, errors.New("NotModified")),
+	}
+	apttransports3go.Fetch(ctx, &buf, api, apttransports3go.NewConfig(), header) //nolint:errcheck
+
+	assert.Equal(fmt.Sprintf(`102 Status
+Message: Waiting for headers
+URI: s3://example.com/key
+
+201 URI Done
+Filename: %s
+IMS-Hit: true
+Last-Modified: Sun, 20 Nov 2022 12:34:56 GMT
+URI: s3://example.com/key
+
+`, dl.Name()), buf.String())
+	assert.True(timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00").Equal(*api.GetObjectInput.IfModifiedSince))
+	assert.Nil(api.HeadObjectInput)
+}
+
+func TestFetch_BadLastModified(t *testing.T) {
+	assert := assert.New(t)
+	dl, _ := os.CreateTemp("", "")
+	defer os.Remove(dl.Name())
+	header := map[string][]string{
+		"URI":           {"s3://example.com/key"},
+		"Filename":      {dl.Name()},
+		"Last-Modified": {"yesterday"},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	api := &MockS3API{
+		Body:          io.NopCloser(strings.NewReader("apt body")),
+		ContentLength: 8,
+		LastModified:  timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00"),
+	}
+	apttransports3go.Fetch(ctx, &buf, api, apttransports3go.NewConfig(), header) //nolint:errcheck
+
+	assert.Contains(buf.String(), "201 URI Done\n")
+	assert.NotContains(buf.String(), "IMS-Hit")
+	assert.Nil(api.GetObjectInput.IfModifiedSince)
+}
+
+func TestFetch_ExpectedHashes(t *testing.T) {
+	assert := assert.New(t)
+	dl, _ := os.CreateTemp("", "")
+	defer os.Remove(dl.Name())
+	header := map[string][]string{
+		"URI":             {"s3://example.com/key"},
+		"Filename":        {dl.Name()},
+		"Expected-MD5Sum": {"600c0724d390c99d2db510c260402a50"},
+		"Expected-SHA256": {"53CE64325A3802023C1922D1EDA5A1D67C1183C31BA509277CFA6350D01CDD85"},
+		"Expected-SHA512": {"e62d8d35da15710e6940c5ed201ddcd1f3debb04879ddd95e091084880b17d3b6c879c019389bd3e49e697c0d58ad14f0358da41f0a9e304eab1319ff1b4e5e3"},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	apttransports3go.Fetch(ctx, &buf, &MockS3API{ //nolint:errcheck
+		Body:          io.NopCloser(strings.NewReader("apt body")),
+		ContentLength: 8,
+		LastModified:  timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00"),
+	}, apttransports3go.NewConfig(), header)
+
+	assert.Contains(buf.String(), "201 URI Done\n")
+	assert.FileExists(dl.Name())
+}
+
+func TestFetch_HashSumMismatch(t *testing.T) {
+	assert := assert.New(t)
+	dl, _ := os.CreateTemp("", "")
+	defer os.Remove(dl.Name())
+	header := map[string][]string{
+		"URI":             {"s3://example.com/key"},
+		"Filename":        {dl.Name()},
+		"Expected-MD5Sum": {"600c0724d390c99d2db510c260402a50"},
+		"Expected-SHA256": {"0000000000000000000000000000000000000000000000000000000000000000"},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	apttransports3go.Fetch(ctx, &buf, &MockS3API{ //nolint:errcheck
+		Body:          io.NopCloser(strings.NewReader("apt body")),
+		ContentLength: 8,
+		LastModified:  timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00"),
+	}, apttransports3go.NewConfig(), header)
+
+	assert.Equal(`102 Status
+Message: Waiting for headers
+URI: s3://example.com/key
+
+200 URI Start
+Last-Modified: Sun, 20 Nov 2022 12:34:56 UTC
+Size: 8
+URI: s3://example.com/key
+
+400 URI Failure
+FailReason: HashSumMismatch
+Message: hash sum mismatch: SHA256 expected 0000000000000000000000000000000000000000000000000000000000000000, but got 53ce64325a3802023c1922d1eda5a1d67c1183c31ba509277cfa6350d01cdd85
+URI: s3://example.com/key
+
+`, buf.String())
+	assert.NoFileExists(dl.Name())
+}
+
+func TestFetch_Resume(t *testing.T) {
+	assert := assert.New(t)
+	lastModified := timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00")
+	dir := t.TempDir()
+	dl := filepath.Join(dir, "key")
+	interruptFetch(t, dl, "apt ", 8, `"etag"`, lastModified)
+	header := map[string][]string{
+		"URI":      {"s3://example.com/key"},
+		"Filename": {dl},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	api := &MockS3API{
+		Content:       "apt body",
+		ContentLength: 8,
+		LastModified:  lastModified,
+		ETag:          `"etag"`,
+	}
+	apttransports3go.Fetch(ctx, &buf, api, apttransports3go.NewConfig(), header) //nolint:errcheck
+
+	assert.Equal(fmt.Sprintf(`102 Status
+Message: Waiting for headers
+URI: s3://example.com/key
+
+200 URI Start
+Last-Modified: Sun, 20 Nov 2022 12:34:56 UTC
+Resume-Point: 4
+Size: 8
+URI: s3://example.com/key
+
+201 URI Done
+Filename: %s
+Last-Modified: Sun, 20 Nov 2022 12:34:56 UTC
+MD5-Hash: 600c0724d390c99d2db510c260402a50
+MD5Sum-Hash: 600c0724d390c99d2db510c260402a50
+SHA256-Hash: 53ce64325a3802023c1922d1eda5a1d67c1183c31ba509277cfa6350d01cdd85
+SHA512-Hash: e62d8d35da15710e6940c5ed201ddcd1f3debb04879ddd95e091084880b17d3b6c879c019389bd3e49e697c0d58ad14f0358da41f0a9e304eab1319ff1b4e5e3
+Size: 8
+URI: s3://example.com/key
+
+`, dl), buf.String())
+	assert.Equal("bytes=4-", *api.GetObjectInput.Range)
+	assert.Equal(`"etag"`, *api.GetObjectInput.IfMatch)
+	content, _ := os.ReadFile(dl)
+	assert.Equal("apt body", string(content))
+	fi, _ := os.Stat(dl)
+	assert.True(lastModified.Equal(fi.ModTime()))
+	// the partial download has become the file
+	entries, _ := os.ReadDir(dir)
+	assert.Len(entries, 1)
+}
+
+func TestFetch_NotResumeModifiedObject(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	dl := filepath.Join(dir, "key")
+	interruptFetch(t, dl, "old ", 8, `"old-etag"`, timeMustParse(time.RFC3339, "2022-11-19T12:34:56+00:00"))
+	header := map[string][]string{
+		"URI":      {"s3://example.com/key"},
+		"Filename": {dl},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	api := &MockS3API{
+		Content:       "apt body",
+		ContentLength: 8,
+		LastModified:  timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00"),
+		ETag:          `"etag"`,
+	}
+	apttransports3go.Fetch(ctx, &buf, api, apttransports3go.NewConfig(), header) //nolint:errcheck
+
+	assert.NotContains(buf.String(), "Resume-Point")
+	assert.Contains(buf.String(), "SHA256-Hash: 53ce64325a3802023c1922d1eda5a1d67c1183c31ba509277cfa6350d01cdd85\n")
+	assert.Nil(api.GetObjectInput.Range)
+	assert.Equal(`"etag"`, *api.GetObjectInput.IfMatch)
+	content, _ := os.ReadFile(dl)
+	assert.Equal("apt body", string(content))
+	entries, _ := os.ReadDir(dir)
+	assert.Len(entries, 1)
+}
+
+type flakyS3API struct {
+	*MockS3API
+	failures int
+	err      error
+}
+
+func (f *flakyS3API) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	if f.failures > 0 {
+		f.failures--
+		return nil, f.err
+	}
+
+	return f.MockS3API.GetObject(ctx, params, optFns...)
+}
+
+func TestFetch_RetryTransientFailure(t *testing.T) {
+	assert := assert.New(t)
+	dl, _ := os.CreateTemp("", "")
+	defer os.Remove(dl.Name())
+	header := map[string][]string{
+		"URI":      {"s3://example.com/key"},
+		"Filename": {dl.Name()},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	cfg := apttransports3go.NewConfig()
+	cfg.Retries = 2
+	cfg.RetryDelay = 0
+	api := &flakyS3API{
+		MockS3API: &MockS3API{
+			Body:          io.NopCloser(strings.NewReader("apt body")),
+			ContentLength: 8,
+			LastModified:  timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00"),
+		},
+		failures: 2,
+		err:      newResponseError(503, &smithy.GenericAPIError{Code: "SlowDown", Message: "Please reduce your request rate."}),
+	}
+	err := apttransports3go.Fetch(ctx, &buf, api, cfg, header)
+
+	assert.NoError(err)
+	assert.Equal(0, api.failures)
+	assert.Contains(buf.String(), "201 URI Done\n")
+	assert.NotContains(buf.String(), "400 URI Failure\n")
+}
+
+func TestFetch_TransientFailure(t *testing.T) {
+	assert := assert.New(t)
+	dl, _ := os.CreateTemp("", "")
+	defer os.Remove(dl.Name())
+	header := map[string][]string{
+		"URI":      {"s3://example.com/key"},
+		"Filename": {dl.Name()},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	cfg := apttransports3go.NewConfig()
+	cfg.Retries = 1
+	cfg.RetryDelay = 0
+	api := &flakyS3API{
+		MockS3API: &MockS3API{},
+		failures:  2,
+		err:       newResponseError(503, &smithy.GenericAPIError{Code: "SlowDown", Message: "Please reduce your request rate."}),
+	}
+	err := apttransports3go.Fetch(ctx, &buf, api, cfg, header)
+
+	assert.NoError(err)
+	assert.Equal(0, api.failures)
+	assert.Equal(`102 Status
+Message: Waiting for headers
+URI: s3://example.com/key
+
+400 URI Failure
+FailReason: Throttling
+Message: https response error StatusCode: 503, RequestID: , api error SlowDown: Please reduce your request rate.
+URI: s3://example.com/key
+
+`, buf.String())
+}
+
+func TestFetch_NotRetryPermanentFailure(t *testing.T) {
+	assert := assert.New(t)
+	dl, _ := os.CreateTemp("", "")
+	defer os.Remove(dl.Name())
+	header := map[string][]string{
+		"URI":      {"s3://example.com/key"},
+		"Filename": {dl.Name()},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	cfg := apttransports3go.NewConfig()
+	cfg.Retries = 3
+	cfg.RetryDelay = 0
+	api := &flakyS3API{
+		MockS3API: &MockS3API{},
+		failures:  3,
+		err:       newResponseError(404, &smithy.GenericAPIError{Code: "NotFound", Message: "Not Found"}),
+	}
+	err := apttransports3go.Fetch(ctx, &buf, api, cfg, header)
+
+	assert.NoError(err)
+	assert.Equal(2, api.failures)
+	assert.Equal(`102 Status
+Message: Waiting for headers
+URI: s3://example.com/key
+
+400 URI Failure
+FailReason: HttpError404
+Message: https response error StatusCode: 404, RequestID: , api error NotFound: Not Found
+URI: s3://example.com/key
+
+`, buf.String())
+}
+
+func TestFetch_TransientFailureWithoutRetries(t *testing.T) {
+	assert := assert.New(t)
+	dl, _ := os.CreateTemp("", "")
+	defer os.Remove(dl.Name())
+	header := map[string][]string{
+		"URI":      {"s3://example.com/key"},
+		"Filename": {dl.Name()},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	api := &flakyS3API{
+		MockS3API: &MockS3API{},
+		failures:  1,
+		err:       newResponseError(503, &smithy.GenericAPIError{Code: "SlowDown", Message: "Please reduce your request rate."}),
+	}
+	err := apttransports3go.Fetch(ctx, &buf, api, apttransports3go.NewConfig(), header)
+
+	assert.NoError(err)
+	assert.Contains(buf.String(), "FailReason: Throttling\n")
+	assert.Contains(buf.String(), "Transient-Failure: true\n")
+}
+
+type errReader struct {
+	r   io.Reader
+	err error
+}
+
+func (e *errReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+
+	if err == io.EOF {
+		err = e.err
+	}
+
+	return n, err
+}
+
+// interruptFetch leaves a partial download of body at fn, as a fetch interrupted by a connection reset does.
+func interruptFetch(t *testing.T, fn string, body string, size int, etag string, lastModified time.Time) {
+	ctx := log.Logger.WithContext(context.Background())
+	err := apttransports3go.Fetch(ctx, io.Discard, &MockS3API{
+		Body:          io.NopCloser(&errReader{r: strings.NewReader(body), err: io.ErrUnexpectedEOF}),
+		ContentLength: size,
+		LastModified:  lastModified,
+		ETag:          etag,
+	}, apttransports3go.NewConfig(), map[string][]string{
+		"URI":      {"s3://example.com/key"},
+		"Filename": {fn},
+	})
+	assert.NoError(t, err)
+}
+
+func TestFetch_CopyErrorKeepsFile(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	dl := filepath.Join(dir, "key")
+	os.WriteFile(dl, []byte("old content"), 0644) //nolint:errcheck
+	header := map[string][]string{
+		"URI":      {"s3://example.com/key"},
+		"Filename": {dl},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	apttransports3go.Fetch(ctx, &buf, &MockS3API{ //nolint:errcheck
+		Body:          io.NopCloser(&errReader{r: strings.NewReader("apt"), err: io.ErrUnexpectedEOF}),
+		ContentLength: 8,
+		LastModified:  timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00"),
+	}, apttransports3go.NewConfig(), header)
+
+	assert.Contains(buf.String(), "400 URI Failure\nFailReason: ConnectionReset\nMessage: unexpected EOF\nTransient-Failure: true\n")
+	content, _ := os.ReadFile(dl)
+	assert.Equal("old content", string(content))
+	// the downloaded bytes are kept to be resumed
+	partial, _ := os.ReadFile(dl + ".s3partial")
+	assert.Equal("apt", string(partial))
+	assert.FileExists(dl + ".s3partial.json")
+}
+
+func TestFetch_ResumeInterruptedFetch(t *testing.T) {
+	assert := assert.New(t)
+	lastModified := timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00")
+	dir := t.TempDir()
+	dl := filepath.Join(dir, "key")
+	header := map[string][]string{
+		"URI":      {"s3://example.com/key"},
+		"Filename": {dl},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	err := apttransports3go.Fetch(ctx, &buf, &MockS3API{
+		Body:          io.NopCloser(&errReader{r: strings.NewReader("apt b"), err: io.ErrUnexpectedEOF}),
+		ContentLength: 8,
+		LastModified:  lastModified,
+		ETag:          `"etag"`,
+	}, apttransports3go.NewConfig(), header)
+
+	assert.NoError(err)
+	assert.Contains(buf.String(), "400 URI Failure\n")
+	assert.NoFileExists(dl)
+
+	buf.Reset()
+	api := &MockS3API{
+		Content:       "apt body",
+		ContentLength: 8,
+		LastModified:  lastModified,
+		ETag:          `"etag"`,
+	}
+	err = apttransports3go.Fetch(ctx, &buf, api, apttransports3go.NewConfig(), header)
+
+	assert.NoError(err)
+	assert.Contains(buf.String(), "Resume-Point: 5\n")
+	assert.Contains(buf.String(), "SHA256-Hash: 53ce64325a3802023c1922d1eda5a1d67c1183c31ba509277cfa6350d01cdd85\n")
+	assert.Equal("bytes=5-", *api.GetObjectInput.Range)
+	content, _ := os.ReadFile(dl)
+	assert.Equal("apt body", string(content))
+	assert.NoFileExists(dl + ".s3partial")
+	assert.NoFileExists(dl + ".s3partial.json")
+}
+
+func TestFetch_AtomicWrite(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	dl := filepath.Join(dir, "key")
+	os.WriteFile(dl, []byte("old content"), 0644) //nolint:errcheck
+	header := map[string][]string{
+		"URI":      {"s3://example.com/key"},
+		"Filename": {dl},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	lastModified := timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00")
+	apttransports3go.Fetch(ctx, &buf, &MockS3API{ //nolint:errcheck
+		Body:          io.NopCloser(strings.NewReader("apt body")),
+		ContentLength: 8,
+		LastModified:  lastModified,
+	}, apttransports3go.NewConfig(), header)
+
+	assert.Contains(buf.String(), "201 URI Done\n")
+	content, _ := os.ReadFile(dl)
+	assert.Equal("apt body", string(content))
+	fi, _ := os.Stat(dl)
+	assert.True(lastModified.Equal(fi.ModTime()))
+	assert.Equal(os.FileMode(0644), fi.Mode().Perm())
+	entries, _ := os.ReadDir(dir)
+	assert.Len(entries, 1)
+}
+
+func TestFetch_HashSumMismatchCleansUp(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	dl := filepath.Join(dir, "key")
+	header := map[string][]string{
+		"URI":             {"s3://example.com/key"},
+		"Filename":        {dl},
+		"Expected-SHA256": {"0000000000000000000000000000000000000000000000000000000000000000"},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	apttransports3go.Fetch(ctx, &buf, &MockS3API{ //nolint:errcheck
+		Body:          io.NopCloser(strings.NewReader("apt body")),
+		ContentLength: 8,
+		LastModified:  timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00"),
+	}, apttransports3go.NewConfig(), header)
+
+	assert.Contains(buf.String(), "FailReason: HashSumMismatch\n")
+	entries, _ := os.ReadDir(dir)
+	assert.Empty(entries)
+}
+
+func TestFetch_Multipart(t *testing.T) {
+	assert := assert.New(t)
+	dl := filepath.Join(t.TempDir(), "key")
+	content := strings.Repeat("apt body", 500000)
+	header := map[string][]string{
+		"URI":             {"s3://example.com/key"},
+		"Filename":        {dl},
+		"Expected-SHA256": {"b0b365b6e25f458b7fd33052cd1b3323e20fd23e9fb31b4ff6c28ab4abcfe49a"},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	cfg := apttransports3go.NewConfig()
+	cfg.Global.PartSize = 1024 * 1024
+	api := &MockS3API{
+		Content:       content,
+		ContentLength: len(content),
+		LastModified:  timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00"),
+		ETag:          `"etag"`,
+	}
+	apttransports3go.Fetch(ctx, &buf, api, cfg, header) //nolint:errcheck
+
+	assert.Contains(buf.String(), "201 URI Done\n")
+	assert.Len(api.GetObjectInputs, 4)
+	// the size is unknown, so the first GET is plain and only its first part is read
+	assert.Nil(api.GetObjectInputs[0].Range)
+	assert.Nil(api.HeadObjectInput)
+	written, _ := os.ReadFile(dl)
+	assert.True(content == string(written))
+}
+
+func TestFetch_MultipartWithMaximumSize(t *testing.T) {
+	assert := assert.New(t)
+	dl := filepath.Join(t.TempDir(), "key")
+	content := strings.Repeat("apt body", 500000)
+	header := map[string][]string{
+		"URI":             {"s3://example.com/key"},
+		"Filename":        {dl},
+		"Expected-SHA256": {"b0b365b6e25f458b7fd33052cd1b3323e20fd23e9fb31b4ff6c28ab4abcfe49a"},
+		"Maximum-Size":    {"4000000"},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	cfg := apttransports3go.NewConfig()
+	cfg.Global.PartSize = 1024 * 1024
+	api := &MockS3API{
+		Content:       content,
+		ContentLength: len(content),
+		LastModified:  timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00"),
+		ETag:          `"etag"`,
+	}
+	apttransports3go.Fetch(ctx, &buf, api, cfg, header) //nolint:errcheck
+
+	assert.Contains(buf.String(), "201 URI Done\n")
+	assert.Len(api.GetObjectInputs, 4)
+	// the object is known to be larger than a part, so the first GET is ranged
+	assert.Equal("bytes=0-1048575", *api.GetObjectInputs[0].Range)
+	written, _ := os.ReadFile(dl)
+	assert.True(content == string(written))
+}
+
+func TestFetch_MultipartFailureKeepsContiguousParts(t *testing.T) {
+	assert := assert.New(t)
+	dl := filepath.Join(t.TempDir(), "key")
+	content := strings.Repeat("apt body", 500000)
+	header := map[string][]string{
+		"URI":             {"s3://example.com/key"},
+		"Filename":        {dl},
+		"Expected-SHA256": {"b0b365b6e25f458b7fd33052cd1b3323e20fd23e9fb31b4ff6c28ab4abcfe49a"},
+	}
+
+	ctx := log.Logger.WithContext(context.Background())
+	cfg := apttransports3go.NewConfig()
+	cfg.Global.PartSize = 1024 * 1024
+	cfg.Global.Concurrency = 2
+	api := &MockS3API{
+		Content:       content,
+		ContentLength: len(content),
+		LastModified:  timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00"),
+		ETag:          `"etag"`,
+		RangeErrors:   map[string]error{"bytes=2097152-3145727": errors.New("connection reset")},
+	}
+
+	var buf strings.Builder
+	apttransports3go.Fetch(ctx, &buf, api, cfg, header) //nolint:errcheck
+
+	assert.Contains(buf.String(), "400 URI Failure\n")
+	assert.Contains(buf.String(), "Message: failed to get part 2097152-3145727: connection reset\n")
+	assert.NoFileExists(dl)
+	partial, _ := os.ReadFile(dl + ".s3partial")
+	assert.True(content[:2097152] == string(partial))
+
+	api = &MockS3API{
+		Content:       content,
+		ContentLength: len(content),
+		LastModified:  timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00"),
+		ETag:          `"etag"`,
+	}
+
+	buf.Reset()
+	apttransports3go.Fetch(ctx, &buf, api, cfg, header) //nolint:errcheck
+
+	assert.Contains(buf.String(), "Resume-Point: 2097152\n")
+	assert.Contains(buf.String(), "201 URI Done\n")
+	assert.Equal("bytes=2097152-3145727", *api.GetObjectInputs[0].Range)
+	written, _ := os.ReadFile(dl)
+	assert.True(content == string(written))
+}
+
+func TestFetch_RangedChecksumFromHeadObject(t *testing.T) {
+	assert := assert.New(t)
+	dl := filepath.Join(t.TempDir(), "key")
+	content := strings.Repeat("apt body", 500000)
+	header := map[string][]string{
+		"URI":          {"s3://example.com/key"},
+		"Filename":     {dl},
+		"Maximum-Size": {"4000000"},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	cfg := apttransports3go.NewConfig()
+	cfg.Global.PartSize = 1024 * 1024
+	api := &MockS3API{
+		Content:        content,
+		ContentLength:  len(content),
+		LastModified:   timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00"),
+		ETag:           `"etag"`,
+		ChecksumSHA256: aptBodySHA256,
+	}
+	apttransports3go.Fetch(ctx, &buf, api, cfg, header) //nolint:errcheck
+
+	// the ranged GET does not return the checksum of the whole object
+	assert.Equal("bytes=0-1048575", *api.GetObjectInputs[0].Range)
+	assert.Equal(`"etag"`, *api.HeadObjectInput.IfMatch)
+	assert.Contains(buf.String(), "400 URI Failure\nFailReason: HashSumMismatch\n")
+	assert.NoFileExists(dl)
+}
+
+func TestFetch_EmptyObject(t *testing.T) {
+	assert := assert.New(t)
+	dl := filepath.Join(t.TempDir(), "key")
+	header := map[string][]string{
+		"URI":      {"s3://example.com/key"},
+		"Filename": {dl},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	api := &MockS3API{
+		Body:         io.NopCloser(strings.NewReader("")),
+		LastModified: timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00"),
+		ETag:         `"etag"`,
+	}
+	apttransports3go.Fetch(ctx, &buf, api, apttransports3go.NewConfig(), header) //nolint:errcheck
+
+	// S3 rejects any range of an empty object
+	assert.Nil(api.GetObjectInput.Range)
+	assert.Nil(api.HeadObjectInput)
+	assert.Contains(buf.String(), "201 URI Done\n")
+	assert.Contains(buf.String(), "Size: 0\n")
+	assert.FileExists(dl)
+}
+
+func TestFetch_WithoutHeadObject(t *testing.T) {
+	assert := assert.New(t)
+	dl := filepath.Join(t.TempDir(), "key")
+	header := map[string][]string{
+		"URI":      {"s3://example.com/key"},
+		"Filename": {dl},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	api := &MockS3API{
+		Body:          io.NopCloser(strings.NewReader("apt body")),
+		ContentLength: 8,
+		LastModified:  timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00"),
+		ETag:          `"etag"`,
+	}
+	apttransports3go.Fetch(ctx, &buf, api, apttransports3go.NewConfig(), header) //nolint:errcheck
+
+	assert.Equal(fmt.Sprintf(`102 Status
+Message: Waiting for headers
+URI: s3://example.com/key
+
 200 URI Start
 Last-Modified: Sun, 20 Nov 2022 12:34:56 UTC
-Size: 100
+Size: 8
+URI: s3://example.com/key
+
+201 URI Done
+Filename: %s
+Last-Modified: Sun, 20 Nov 2022 12:34:56 UTC
+MD5-Hash: 600c0724d390c99d2db510c260402a50
+MD5Sum-Hash: 600c0724d390c99d2db510c260402a50
+SHA256-Hash: 53ce64325a3802023c1922d1eda5a1d67c1183c31ba509277cfa6350d01cdd85
+SHA512-Hash: e62d8d35da15710e6940c5ed201ddcd1f3debb04879ddd95e091084880b17d3b6c879c019389bd3e49e697c0d58ad14f0358da41f0a9e304eab1319ff1b4e5e3
+Size: 8
+URI: s3://example.com/key
+
+`, dl), buf.String())
+	assert.Nil(api.HeadObjectInput)
+	assert.Nil(api.GetObjectInput.IfMatch)
+}
+
+func TestFetch_MaximumSizeExceeded(t *testing.T) {
+	assert := assert.New(t)
+	dl := filepath.Join(t.TempDir(), "key")
+	header := map[string][]string{
+		"URI":          {"s3://example.com/key"},
+		"Filename":     {dl},
+		"Maximum-Size": {"4"},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	api := &MockS3API{
+		Body:          io.NopCloser(strings.NewReader("apt body")),
+		ContentLength: 8,
+		LastModified:  timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00"),
+	}
+	err := apttransports3go.Fetch(ctx, &buf, api, apttransports3go.NewConfig(), header)
+
+	assert.NoError(err)
+	assert.Equal(`102 Status
+Message: Waiting for headers
 URI: s3://example.com/key
 
 400 URI Failure
-Message: GetObjectError
+FailReason: MaximumSizeExceeded
+Message: file is larger than expected (8 > 4)
+URI: s3://example.com/key
+
+`, buf.String())
+	assert.NoFileExists(dl)
+}
+
+func TestFetch_MaximumSizeExceededOnHeadObject(t *testing.T) {
+	assert := assert.New(t)
+	dl := filepath.Join(t.TempDir(), "key")
+	os.WriteFile(dl+".s3partial", []byte("apt "), 0644) //nolint:errcheck
+	header := map[string][]string{
+		"URI":          {"s3://example.com/key"},
+		"Filename":     {dl},
+		"Maximum-Size": {"4"},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	api := &MockS3API{
+		Body:          io.NopCloser(strings.NewReader("apt body")),
+		ContentLength: 8,
+		LastModified:  timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00"),
+	}
+	err := apttransports3go.Fetch(ctx, &buf, api, apttransports3go.NewConfig(), header)
+
+	assert.NoError(err)
+	assert.Contains(buf.String(), "FailReason: MaximumSizeExceeded\n")
+	assert.NotNil(api.HeadObjectInput)
+	assert.Nil(api.GetObjectInput)
+}
+
+func TestFetch_WithinMaximumSize(t *testing.T) {
+	assert := assert.New(t)
+	dl := filepath.Join(t.TempDir(), "key")
+	header := map[string][]string{
+		"URI":      {"s3://example.com/key"},
+		"Filename": {dl},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	api := &MockS3API{
+		Body:          io.NopCloser(strings.NewReader("apt body")),
+		ContentLength: 8,
+		LastModified:  timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00"),
+	}
+	err := apttransports3go.Fetch(ctx, &buf, api, apttransports3go.NewConfig(), header)
+
+	assert.NoError(err)
+	assert.Contains(buf.String(), "201 URI Done\n")
+}
+
+func TestFetch_ShortRead(t *testing.T) {
+	assert := assert.New(t)
+	dl := filepath.Join(t.TempDir(), "key")
+	header := map[string][]string{
+		"URI":      {"s3://example.com/key"},
+		"Filename": {dl},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	api := &MockS3API{
+		Body:          io.NopCloser(strings.NewReader("apt")),
+		ContentLength: 8,
+		LastModified:  timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00"),
+	}
+	err := apttransports3go.Fetch(ctx, &buf, api, apttransports3go.NewConfig(), header)
+
+	assert.NoError(err)
+	assert.Contains(buf.String(), `400 URI Failure
+FailReason: ConnectionReset
+Message: unexpected EOF: got 3 bytes, but expected 8 bytes
+Transient-Failure: true
+`)
+	assert.NoFileExists(dl)
+}
+
+func TestFetch_LongRead(t *testing.T) {
+	assert := assert.New(t)
+	dl := filepath.Join(t.TempDir(), "key")
+	header := map[string][]string{
+		"URI":      {"s3://example.com/key"},
+		"Filename": {dl},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	api := &MockS3API{
+		Body:          io.NopCloser(strings.NewReader("apt body and more")),
+		ContentLength: 8,
+		LastModified:  timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00"),
+	}
+	err := apttransports3go.Fetch(ctx, &buf, api, apttransports3go.NewConfig(), header)
+
+	assert.NoError(err)
+	assert.Contains(buf.String(), `400 URI Failure
+Message: data is larger than the declared size
+`)
+	assert.NoFileExists(dl)
+}
+
+func TestFetch_VersionID(t *testing.T) {
+	assert := assert.New(t)
+	dl := filepath.Join(t.TempDir(), "key")
+	os.WriteFile(dl+".s3partial", []byte("apt "), 0644) //nolint:errcheck
+	header := map[string][]string{
+		"URI":      {"s3://example.com/key?versionId=3HL4kqtJlcpXroDTDmJ.rmSpXd3dIbrHY"},
+		"Filename": {dl},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	api := &MockS3API{
+		Body:          io.NopCloser(strings.NewReader("apt body")),
+		ContentLength: 8,
+		LastModified:  timeMustParse(time.RFC3339, "2022-11-20T12:34:56+00:00"),
+	}
+	err := apttransports3go.Fetch(ctx, &buf, api, apttransports3go.NewConfig(), header)
+
+	assert.NoError(err)
+	assert.Contains(buf.String(), "201 URI Done\n")
+	assert.Equal("key", *api.HeadObjectInput.Key)
+	assert.Equal("3HL4kqtJlcpXroDTDmJ.rmSpXd3dIbrHY", *api.HeadObjectInput.VersionId)
+	assert.Equal("key", *api.GetObjectInput.Key)
+	assert.Equal("3HL4kqtJlcpXroDTDmJ.rmSpXd3dIbrHY", *api.GetObjectInput.VersionId)
+}
+
+func TestFetch_AccessPointARN(t *testing.T) {
+	assert := assert.New(t)
+
+	tt := []struct {
+		uri    string
+		bucket string
+		key    string
+	}{
+		{"s3://arn:aws:s3:us-west-2:123456789012:accesspoint/my-ap/dists/stable/Release", "arn:aws:s3:us-west-2:123456789012:accesspoint/my-ap", "dists/stable/Release"},
+		{"s3://arn:aws:s3::123456789012:accesspoint/mfzwi23gnjvgw.mrap/key?versionId=v1", "arn:aws:s3::123456789012:accesspoint/mfzwi23gnjvgw.mrap", "key"},
+	}
+
+	for _, t_ := range tt {
+		dl := filepath.Join(t.TempDir(), "key")
+		header := map[string][]string{
+			"URI":      {t_.uri},
+			"Filename": {dl},
+		}
+
+		var buf strings.Builder
+		ctx := log.Logger.WithContext(context.Background())
+		api := &MockS3API{
+			Body:          io.NopCloser(strings.NewReader("apt body")),
+			ContentLength: 8,
+		}
+		err := apttransports3go.Fetch(ctx, &buf, api, apttransports3go.NewConfig(), header)
+
+		assert.NoError(err)
+		assert.Contains(buf.String(), "201 URI Done\n")
+		assert.Equal(t_.bucket, *api.GetObjectInput.Bucket)
+		assert.Equal(t_.key, *api.GetObjectInput.Key)
+	}
+}
+
+func TestFetch_BadAccessPointARN(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, uri := range []string{
+		"s3://arn:aws:s3:us-west-2:123456789012",
+		"s3://arn:aws:s3:us-west-2:123456789012:bucket/my-bucket/key",
+		"s3://arn:aws:s3:us-west-2:123456789012:accesspoint",
+	} {
+		header := map[string][]string{
+			"URI":      {uri},
+			"Filename": {"/tmp/key"},
+		}
+
+		var buf strings.Builder
+		ctx := log.Logger.WithContext(context.Background())
+		err := apttransports3go.Fetch(ctx, &buf, &MockS3API{}, apttransports3go.NewConfig(), header)
+		assert.NoError(err, uri)
+		assert.Contains(buf.String(), "400 URI Failure\nFailReason: BadURI\nMessage: bad URI: ", uri)
+	}
+}
+
+func TestFetch_RequesterPays(t *testing.T) {
+	assert := assert.New(t)
+	dl := filepath.Join(t.TempDir(), "key")
+	os.WriteFile(dl+".s3partial", []byte("apt "), 0644) //nolint:errcheck
+	header := map[string][]string{
+		"URI":      {"s3://example.com/key"},
+		"Filename": {dl},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	cfg := apttransports3go.NewConfig()
+	cfg.Global.RequesterPays = true
+	api := &MockS3API{
+		Body:          io.NopCloser(strings.NewReader("apt body")),
+		ContentLength: 8,
+	}
+	err := apttransports3go.Fetch(ctx, &buf, api, cfg, header)
+
+	assert.NoError(err)
+	assert.Contains(buf.String(), "201 URI Done\n")
+	assert.Equal(types.RequestPayerRequester, api.HeadObjectInput.RequestPayer)
+	assert.Equal(types.RequestPayerRequester, api.GetObjectInput.RequestPayer)
+}
+
+func TestFetch_RequesterPaysHint(t *testing.T) {
+	assert := assert.New(t)
+	header := map[string][]string{
+		"URI":      {"s3://example.com/key"},
+		"Filename": {filepath.Join(t.TempDir(), "key")},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	api := &MockS3API{
+		GetObjectError: newResponseError(403, &smithy.GenericAPIError{Code: "AccessDenied", Message: "Access Denied"}),
+	}
+	err := apttransports3go.Fetch(ctx, &buf, api, apttransports3go.NewConfig(), header)
+
+	assert.NoError(err)
+	assert.Equal(types.RequestPayer(""), api.GetObjectInput.RequestPayer)
+	assert.Contains(buf.String(), `400 URI Failure
+FailReason: HttpError403
+Message: https response error StatusCode: 403, RequestID: , api error AccessDenied: Access Denied (set Acquire::s3::RequesterPays if the bucket is Requester Pays)
+`)
+
+	// no hint when the option is already set
+	buf.Reset()
+	cfg := apttransports3go.NewConfig()
+	cfg.Global.RequesterPays = true
+	err = apttransports3go.Fetch(ctx, &buf, api, cfg, header)
+
+	assert.NoError(err)
+	assert.NotContains(buf.String(), "RequesterPays")
+}
+
+func TestFetch_RequesterPaysHintOnlyForAccessDenied(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, code := range []string{"SignatureDoesNotMatch", "InvalidAccessKeyId"} {
+		header := map[string][]string{
+			"URI":      {"s3://example.com/key"},
+			"Filename": {filepath.Join(t.TempDir(), "key")},
+		}
+
+		var buf strings.Builder
+		ctx := log.Logger.WithContext(context.Background())
+		api := &MockS3API{
+			GetObjectError: newResponseError(403, &smithy.GenericAPIError{Code: code, Message: code}),
+		}
+		err := apttransports3go.Fetch(ctx, &buf, api, apttransports3go.NewConfig(), header)
+
+		assert.NoError(err, code)
+		// the credentials are wrong, which RequesterPays does not fix
+		assert.Contains(buf.String(), "400 URI Failure\nFailReason: HttpError403\n", code)
+		assert.NotContains(buf.String(), "RequesterPays", code)
+	}
+}
+
+func TestFetch_ExpectedBucketOwner(t *testing.T) {
+	assert := assert.New(t)
+	dl := filepath.Join(t.TempDir(), "key")
+	os.WriteFile(dl+".s3partial", []byte("apt "), 0644) //nolint:errcheck
+	header := map[string][]string{
+		"URI":      {"s3://example.com/key"},
+		"Filename": {dl},
+	}
+
+	ctx := log.Logger.WithContext(context.Background())
+	cfg := apttransports3go.NewConfig()
+	cfg.Global.ExpectedBucketOwner = "123456789012"
+	api := &MockS3API{
+		Body:          io.NopCloser(strings.NewReader("apt body")),
+		ContentLength: 8,
+	}
+	err := apttransports3go.Fetch(ctx, io.Discard, api, cfg, header)
+
+	assert.NoError(err)
+	assert.Equal("123456789012", *api.HeadObjectInput.ExpectedBucketOwner)
+	assert.Equal("123456789012", *api.GetObjectInput.ExpectedBucketOwner)
+}
+
+func TestFetch_BucketOwnerMismatch(t *testing.T) {
+	assert := assert.New(t)
+	header := map[string][]string{
+		"URI":      {"s3://example.com/key"},
+		"Filename": {filepath.Join(t.TempDir(), "key")},
+	}
+
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	cfg := apttransports3go.NewConfig()
+	cfg.Retries = 3
+	cfg.Global.ExpectedBucketOwner = "123456789012"
+	api := &flakyS3API{
+		MockS3API: &MockS3API{BucketOwner: "210987654321"},
+		failures:  5,
+		err:       newResponseError(403, &smithy.GenericAPIError{Code: "AccessDenied", Message: "Access Denied"}),
+	}
+	err := apttransports3go.Fetch(ctx, &buf, api, cfg, header)
+
+	assert.NoError(err)
+	// not retried
+	assert.Equal(4, api.failures)
+	assert.Equal(`102 Status
+Message: Waiting for headers
+URI: s3://example.com/key
+
+400 URI Failure
+FailReason: BucketOwnerMismatch
+Message: https response error StatusCode: 403, RequestID: , api error AccessDenied: Access Denied (the bucket is not owned by 123456789012)
 URI: s3://example.com/key
 
 `, buf.String())
+	// the mismatch is confirmed with and without the expected owner
+	assert.Len(api.HeadBucketInputs, 2)
+	assert.Nil(api.HeadBucketInputs[0].ExpectedBucketOwner)
+	assert.Equal("123456789012", *api.HeadBucketInputs[1].ExpectedBucketOwner)
+}
+
+func TestFetch_BucketOwnerNotConfirmed(t *testing.T) {
+	forbidden := newResponseError(403, &smithy.GenericAPIError{Code: "AccessDenied", Message: "Access Denied"})
+
+	for name, api := range map[string]*MockS3API{
+		// the 403 is not caused by the owner
+		"owner matches": {BucketOwner: "123456789012"},
+		// e.g. no permission for HeadBucket
+		"head bucket forbidden": {HeadBucketError: forbidden},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			header := map[string][]string{
+				"URI":      {"s3://example.com/key"},
+				"Filename": {filepath.Join(t.TempDir(), "key")},
+			}
+
+			var buf strings.Builder
+			ctx := log.Logger.WithContext(context.Background())
+			cfg := apttransports3go.NewConfig()
+			cfg.Global.ExpectedBucketOwner = "123456789012"
+			api.GetObjectError = forbidden
+			err := apttransports3go.Fetch(ctx, &buf, api, cfg, header)
+
+			assert.NoError(err)
+			assert.Contains(buf.String(), `400 URI Failure
+FailReason: HttpError403
+Message: https response error StatusCode: 403, RequestID: , api error AccessDenied: Access Denied (set Acquire::s3::RequesterPays if the bucket is Requester Pays)
+`)
+		})
+	}
+}
+
+func TestFetch_BucketOwnerMismatchByClient(t *testing.T) {
+	assert := assert.New(t)
+	ts := newS3Server(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead && r.URL.Path == "/my-bucket" && r.Header.Get("X-Amz-Expected-Bucket-Owner") == "" {
+			return
+		}
+
+		w.WriteHeader(http.StatusForbidden)
+	})
+
+	ctx := log.Logger.WithContext(context.Background())
+	cfg, _ := apttransports3go.Configure(ctx, map[string][]string{
+		"Config-Item": {
+			"Acquire::s3::Endpoint=" + ts.URL,
+			"Acquire::s3::UsePathStyle=true",
+			"Acquire::s3::ExpectedBucketOwner=123456789012",
+		},
+	})
+
+	var buf strings.Builder
+	err := apttransports3go.Fetch(ctx, &buf, apttransports3go.NewClient(cfg), cfg, map[string][]string{
+		"URI":      {"s3://my-bucket/key"},
+		"Filename": {filepath.Join(t.TempDir(), "key")},
+	})
+
+	assert.NoError(err)
+	assert.Contains(buf.String(), "FailReason: BucketOwnerMismatch\n")
+	assert.Contains(buf.String(), "(the bucket is not owned by 123456789012)\n")
 }
@@ -2,9 +2,17 @@ package apttransports3go_test
 
 import (
 	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/assert"
 	apttransports3go "github.com/winebarrel/apt-transport-s3-go"
@@ -21,7 +29,9 @@ Config-Item: Acquire::http::Proxy=http://example.com
 	err := apttransports3go.Run(ctx, r, &buf)
 
 	assert.Equal(`100 Capabilities
+Pipeline: true
 Send-Config: true
+Send-URI-Encoded: true
 Single-Instance: true
 Version: 1.1
 
@@ -45,20 +55,427 @@ func TestSendCapabilities_OK(t *testing.T) {
 	apttransports3go.SendCapabilities(ctx, &buf)
 
 	assert.Equal(`100 Capabilities
+Pipeline: true
 Send-Config: true
+Send-URI-Encoded: true
 Single-Instance: true
 Version: 1.1
 
 `, buf.String())
 }
 
-func TestConfigure_OK(t *testing.T) {
+func TestRun_Acquire(t *testing.T) {
 	assert := assert.New(t)
-	header := map[string][]string{
-		"Config-Item": {"Acquire::http::Proxy=http://example.com"},
+	ts := newS3Server(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	r := strings.NewReader(`601 Configuration
+Config-Item: Acquire::s3::Endpoint=` + ts.URL + `
+Config-Item: Acquire::s3::UsePathStyle=true
+
+600 URI Acquire
+URI: s3://my-bucket/foo
+Filename: /tmp/foo
+
+600 URI Acquire
+URI: s3://my-bucket/bar
+Filename: /tmp/bar
+
+`)
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	err := apttransports3go.Run(ctx, r, &buf)
+	assert.NoError(err)
+
+	// every message must be complete even when sent from concurrent fetches
+	msgs := strings.Split(strings.TrimSuffix(buf.String(), "\n\n"), "\n\n")
+	assert.Len(msgs, 5)
+
+	for _, msg := range msgs[1:] {
+		assert.Regexp(regexp.MustCompile(`\A(102 Status|400 URI Failure)\n`), msg)
 	}
 
+	assert.Equal(2, strings.Count(buf.String(), "400 URI Failure\n"))
+}
+
+type syncBuilder struct {
+	mu  sync.Mutex
+	buf strings.Builder
+}
+
+func (b *syncBuilder) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuilder) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRun_AuthorizationCredentials(t *testing.T) {
+	assert := assert.New(t)
+	ts := newS3Server(t, func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Authorization"), "Credential=AKIAFROMAPT/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("Last-Modified", "Sun, 20 Nov 2022 12:34:56 GMT")
+		w.Write([]byte("apt body")) //nolint:errcheck
+	})
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	dl := filepath.Join(t.TempDir(), "key")
+
+	r, pw := io.Pipe()
+	var buf syncBuilder
 	ctx := log.Logger.WithContext(context.Background())
-	_, err := apttransports3go.Configure(ctx, header)
+	done := make(chan error)
+	go func() { done <- apttransports3go.Run(ctx, r, &buf) }()
+
+	io.WriteString(pw, `601 Configuration
+Config-Item: Dir::Etc=`+t.TempDir()+`
+Config-Item: Acquire::s3::region=ap-northeast-1
+Config-Item: Acquire::s3::Endpoint=`+ts.URL+`
+Config-Item: Acquire::s3::UsePathStyle=true
+
+600 URI Acquire
+URI: s3://my-bucket/key
+Filename: `+dl+`
+
+`) //nolint:errcheck
+
+	assert.Eventually(func() bool {
+		return strings.Contains(buf.String(), "402 Authorization Required\nSite: my-bucket\n\n")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	io.WriteString(pw, `602 Authorization Credentials
+Site: my-bucket
+User: AKIAFROMAPT
+Password: secret
+
+`) //nolint:errcheck
+	pw.Close()
+
+	assert.NoError(<-done)
+	assert.Contains(buf.String(), "201 URI Done\n")
+	content, _ := os.ReadFile(dl)
+	assert.Equal("apt body", string(content))
+}
+
+func TestRun_NoAuthorizationCredentials(t *testing.T) {
+	assert := assert.New(t)
+	ts := newS3Server(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	})
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+
+	r := strings.NewReader(`601 Configuration
+Config-Item: Dir::Etc=` + t.TempDir() + `
+Config-Item: Acquire::s3::Endpoint=` + ts.URL + `
+
+600 URI Acquire
+URI: s3://my-bucket/key
+Filename: /tmp/key
+
+`)
+	var buf syncBuilder
+	ctx := log.Logger.WithContext(context.Background())
+	err := apttransports3go.Run(ctx, r, &buf)
 	assert.NoError(err)
+	assert.Contains(buf.String(), "400 URI Failure\nFailReason: HttpError403\nMessage: no authorization credentials\nURI: s3://my-bucket/key\n\n")
+}
+
+func TestRun_AuthorizationTimeout(t *testing.T) {
+	assert := assert.New(t)
+	defer func(d time.Duration) { *apttransports3go.AuthorizationTimeout = d }(*apttransports3go.AuthorizationTimeout)
+	*apttransports3go.AuthorizationTimeout = 500 * time.Millisecond
+	ts := newS3Server(t, func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Authorization"), "Credential=AKIAAUTHCONF/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("Last-Modified", "Sun, 20 Nov 2022 12:34:56 GMT")
+		w.Write([]byte("apt body")) //nolint:errcheck
+	})
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	etc := t.TempDir()
+	os.WriteFile(filepath.Join(etc, "auth.conf"), []byte("machine other-bucket login AKIAAUTHCONF password secret"), 0600) //nolint:errcheck
+	dir := t.TempDir()
+
+	// like apt without a handler for 402, stdin stays open but credentials never come
+	r, pw := io.Pipe()
+	defer pw.Close()
+	var buf syncBuilder
+	ctx := log.Logger.WithContext(context.Background())
+	go apttransports3go.Run(ctx, r, &buf) //nolint:errcheck
+
+	io.WriteString(pw, `601 Configuration
+Config-Item: Dir::Etc=`+etc+`
+Config-Item: Acquire::s3::region=ap-northeast-1
+Config-Item: Acquire::s3::Endpoint=`+ts.URL+`
+Config-Item: Acquire::s3::UsePathStyle=true
+
+600 URI Acquire
+URI: s3://my-bucket/key
+Filename: `+filepath.Join(dir, "my-bucket")+`
+
+600 URI Acquire
+URI: s3://other-bucket/key
+Filename: `+filepath.Join(dir, "other-bucket")+`
+
+`) //nolint:errcheck
+
+	// the waiting fetch does not block the other bucket
+	assert.Eventually(func() bool {
+		return strings.Contains(buf.String(), "201 URI Done\n")
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.NotContains(buf.String(), "400 URI Failure\n")
+
+	assert.Eventually(func() bool {
+		return strings.Contains(buf.String(), "400 URI Failure\n")
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Contains(buf.String(), `400 URI Failure
+FailReason: HttpError403
+Message: no authorization credentials: no reply to 402 Authorization Required in 500ms
+URI: s3://my-bucket/key
+
+`)
+}
+
+func TestRun_AuthorizationRetriedAfterTimeout(t *testing.T) {
+	assert := assert.New(t)
+	defer func(d time.Duration) { *apttransports3go.AuthorizationTimeout = d }(*apttransports3go.AuthorizationTimeout)
+	*apttransports3go.AuthorizationTimeout = 300 * time.Millisecond
+	ts := newS3Server(t, func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Authorization"), "Credential=AKIAFROMAPT/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("Last-Modified", "Sun, 20 Nov 2022 12:34:56 GMT")
+		w.Write([]byte("apt body")) //nolint:errcheck
+	})
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	dl := filepath.Join(t.TempDir(), "key")
+
+	r, pw := io.Pipe()
+	var buf syncBuilder
+	ctx := log.Logger.WithContext(context.Background())
+	done := make(chan error)
+	go func() { done <- apttransports3go.Run(ctx, r, &buf) }()
+
+	io.WriteString(pw, `601 Configuration
+Config-Item: Dir::Etc=`+t.TempDir()+`
+Config-Item: Acquire::s3::region=ap-northeast-1
+Config-Item: Acquire::s3::Endpoint=`+ts.URL+`
+Config-Item: Acquire::s3::UsePathStyle=true
+
+600 URI Acquire
+URI: s3://my-bucket/key
+Filename: `+dl+`
+
+`) //nolint:errcheck
+
+	assert.Eventually(func() bool {
+		return strings.Contains(buf.String(), "400 URI Failure\n")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the failed resolution is not kept, so the next fetch asks apt again
+	io.WriteString(pw, `600 URI Acquire
+URI: s3://my-bucket/key
+Filename: `+dl+`
+
+`) //nolint:errcheck
+
+	assert.Eventually(func() bool {
+		return strings.Count(buf.String(), "402 Authorization Required\nSite: my-bucket\n\n") == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	io.WriteString(pw, `602 Authorization Credentials
+Site: my-bucket
+User: AKIAFROMAPT
+Password: secret
+
+`) //nolint:errcheck
+
+	assert.Eventually(func() bool {
+		return strings.Contains(buf.String(), "201 URI Done\n")
+	}, 5*time.Second, 10*time.Millisecond)
+	pw.Close()
+
+	assert.NoError(<-done)
+	content, _ := os.ReadFile(dl)
+	assert.Equal("apt body", string(content))
+}
+
+func TestRun_BadURI(t *testing.T) {
+	assert := assert.New(t)
+	ts := newS3Server(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", "Sun, 20 Nov 2022 12:34:56 GMT")
+		w.Write([]byte("apt body")) //nolint:errcheck
+	})
+	dir := t.TempDir()
+	r := strings.NewReader(`601 Configuration
+Config-Item: Acquire::s3::Endpoint=` + ts.URL + `
+Config-Item: Acquire::s3::UsePathStyle=true
+
+600 URI Acquire
+URI: s3://my-bucket/pool/main/f/foo/foo_100%_all.deb
+Filename: ` + filepath.Join(dir, "bad") + `
+
+600 URI Acquire
+URI: s3://my-bucket/key
+Filename: ` + filepath.Join(dir, "good") + `
+
+`)
+	var buf syncBuilder
+	ctx := log.Logger.WithContext(context.Background())
+	err := apttransports3go.Run(ctx, r, &buf)
+
+	// the other fetches go on
+	assert.NoError(err)
+	assert.Contains(buf.String(), "400 URI Failure\nFailReason: BadURI\n")
+	assert.Contains(buf.String(), "201 URI Done\n")
+	content, _ := os.ReadFile(filepath.Join(dir, "good"))
+	assert.Equal("apt body", string(content))
+}
+
+func TestRun_Log(t *testing.T) {
+	assert := assert.New(t)
+	ts := newS3Server(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	r := strings.NewReader(`601 Configuration
+Config-Item: Acquire::s3::Endpoint=` + ts.URL + `
+Config-Item: Acquire::s3::UsePathStyle=true
+
+600 URI Acquire
+URI: s3://my-bucket/foo
+Filename: /tmp/foo
+Last-Modified: yesterday
+
+`)
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	err := apttransports3go.Run(ctx, r, &buf)
+	assert.NoError(err)
+	assert.Contains(buf.String(), `101 Log
+Message: warn: ignore bad Last-Modified error=parsing time "yesterday"`)
+	assert.NotContains(buf.String(), "Message: debug: ")
+}
+
+func TestRun_DebugLog(t *testing.T) {
+	assert := assert.New(t)
+	ts := newS3Server(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	r := strings.NewReader(`601 Configuration
+Config-Item: Acquire::s3::Endpoint=` + ts.URL + `
+Config-Item: Acquire::s3::UsePathStyle=true
+Config-Item: Debug::Acquire::s3=true
+
+600 URI Acquire
+URI: s3://my-bucket/foo
+Filename: /tmp/foo
+
+`)
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	level := zerolog.GlobalLevel()
+	err := apttransports3go.Run(ctx, r, &buf)
+	assert.NoError(err)
+	assert.Contains(buf.String(), `101 Log
+Message: debug: start fetch uri=s3://my-bucket/foo
+`)
+	assert.Equal(level, zerolog.GlobalLevel())
+}
+
+func TestRun_LogKeepsCallerLogger(t *testing.T) {
+	assert := assert.New(t)
+	ts := newS3Server(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	r := strings.NewReader(`601 Configuration
+Config-Item: Acquire::s3::Endpoint=` + ts.URL + `
+Config-Item: Acquire::s3::UsePathStyle=true
+Config-Item: Debug::Acquire::s3=true
+
+600 URI Acquire
+URI: s3://my-bucket/foo
+Filename: /tmp/foo
+Last-Modified: yesterday
+
+`)
+	var buf strings.Builder
+	var callerBuf syncBuilder
+	ctx := zerolog.New(&callerBuf).Level(zerolog.InfoLevel).With().Int("pid", 1).Logger().WithContext(context.Background())
+	err := apttransports3go.Run(ctx, r, &buf)
+	assert.NoError(err)
+	assert.Contains(buf.String(), "Message: debug: start fetch uri=s3://my-bucket/foo\n")
+	// the caller's logger gets the logs with its own level and fields
+	assert.Contains(callerBuf.String(), `{"level":"warn","pid":1,"error":"parsing time \"yesterday\"`)
+	assert.NotContains(callerBuf.String(), `"level":"debug"`)
+}
+
+func TestRun_DebugLogTurnedOff(t *testing.T) {
+	assert := assert.New(t)
+	ts := newS3Server(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	r := strings.NewReader(`601 Configuration
+Config-Item: Debug::Acquire::s3=true
+
+601 Configuration
+Config-Item: Acquire::s3::Endpoint=` + ts.URL + `
+Config-Item: Acquire::s3::UsePathStyle=true
+
+600 URI Acquire
+URI: s3://my-bucket/foo
+Filename: /tmp/foo
+
+`)
+	var buf strings.Builder
+	ctx := log.Logger.WithContext(context.Background())
+	err := apttransports3go.Run(ctx, r, &buf)
+	assert.NoError(err)
+	assert.Contains(buf.String(), "400 URI Failure\n")
+	assert.NotContains(buf.String(), "Message: debug: start fetch")
+}
+
+func TestRun_DebugLogHidesPassword(t *testing.T) {
+	assert := assert.New(t)
+	r, pw := io.Pipe()
+	var buf syncBuilder
+	ctx := log.Logger.WithContext(context.Background())
+	done := make(chan error)
+	go func() { done <- apttransports3go.Run(ctx, r, &buf) }()
+
+	io.WriteString(pw, `601 Configuration
+Config-Item: Debug::Acquire::s3=true
+
+`) //nolint:errcheck
+
+	assert.Eventually(func() bool {
+		return strings.Contains(buf.String(), "Message: debug: ")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	io.WriteString(pw, `602 Authorization Credentials
+Site: my-bucket
+User: AKIAFROMAPT
+Password: SUPERSECRET
+
+`) //nolint:errcheck
+	pw.Close()
+
+	assert.NoError(<-done)
+	assert.Contains(buf.String(), "Message: debug: read header line=Password: ********\n")
+	assert.NotContains(buf.String(), "SUPERSECRET")
 }
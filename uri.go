@@ -0,0 +1,74 @@
+package apttransports3go
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+)
+
+type s3URI struct {
+	// bucket name, alias or access point ARN
+	bucket string
+	key    string
+	query  url.Values
+}
+
+// parseS3URI parses "s3://<bucket>/<key>" and "s3://<access point ARN>/<key>",
+// e.g. "s3://arn:aws:s3:us-west-2:123456789012:accesspoint/my-ap/key".
+// The key is percent-decoded exactly once as apt sends encoded URIs (Send-URI-Encoded).
+func parseS3URI(uriStr string) (*s3URI, error) {
+	if len(uriStr) < 5 || !strings.EqualFold(uriStr[:5], "s3://") {
+		return nil, fmt.Errorf("not an s3:// URI")
+	}
+
+	rest, rawQuery, _ := strings.Cut(uriStr[5:], "?")
+	query, err := url.ParseQuery(rawQuery)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var bucket, path string
+
+	if strings.HasPrefix(rest, "arn:") {
+		bucket, path, err = splitARN(rest)
+
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		bucket, path, _ = strings.Cut(rest, "/")
+	}
+
+	if bucket == "" {
+		return nil, fmt.Errorf("no bucket")
+	}
+
+	// unlike in a query, "+" in a path is not a space
+	key, err := url.PathUnescape(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3URI{bucket: bucket, key: key, query: query}, nil
+}
+
+// splitARN splits "arn:<partition>:s3:<region>:<account>:accesspoint/<name>/<key>" into the ARN and the key.
+func splitARN(s string) (string, string, error) {
+	if !arn.IsARN(s) {
+		return "", "", fmt.Errorf("bad ARN: %s", s)
+	}
+
+	fields := strings.SplitN(s, ":", 6)
+	typ, rest, _ := strings.Cut(fields[5], "/")
+	name, key, _ := strings.Cut(rest, "/")
+
+	if typ != "accesspoint" || name == "" {
+		return "", "", fmt.Errorf("not an access point ARN: %s", s)
+	}
+
+	return strings.Join(fields[:5], ":") + ":" + typ + "/" + name, key, nil
+}
@@ -0,0 +1,87 @@
+package apttransports3go_test
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	apttransports3go "github.com/winebarrel/apt-transport-s3-go"
+)
+
+func TestFetch_DecodeKey(t *testing.T) {
+	assert := assert.New(t)
+
+	tt := []struct {
+		uri    string
+		bucket string
+		key    string
+	}{
+		{"s3://my-bucket/pool/main/a/apt/apt_2.6.1_amd64.deb", "my-bucket", "pool/main/a/apt/apt_2.6.1_amd64.deb"},
+		// "+" is literal in a path
+		{"s3://my-bucket/pool/main/f/foo/foo_2.0+dfsg-1_amd64.deb", "my-bucket", "pool/main/f/foo/foo_2.0+dfsg-1_amd64.deb"},
+		{"s3://my-bucket/pool/main/f/foo/foo_2.0%2Bdfsg-1_amd64.deb", "my-bucket", "pool/main/f/foo/foo_2.0+dfsg-1_amd64.deb"},
+		{"s3://my-bucket/pool/main/f/foo/foo_2.0%2bdfsg-1_amd64.deb", "my-bucket", "pool/main/f/foo/foo_2.0+dfsg-1_amd64.deb"},
+		{"s3://my-bucket/pool/main/f/foo/foo_1.0~rc1-1_amd64.deb", "my-bucket", "pool/main/f/foo/foo_1.0~rc1-1_amd64.deb"},
+		{"s3://my-bucket/pool/main/f/foo/foo_1.0%7Erc1-1_amd64.deb", "my-bucket", "pool/main/f/foo/foo_1.0~rc1-1_amd64.deb"},
+		// epoch
+		{"s3://my-bucket/pool/main/f/foo/foo_1:2.0-1_amd64.deb", "my-bucket", "pool/main/f/foo/foo_1:2.0-1_amd64.deb"},
+		{"s3://my-bucket/pool/main/f/foo/foo_1%3a2.0-1_amd64.deb", "my-bucket", "pool/main/f/foo/foo_1:2.0-1_amd64.deb"},
+		// "%" in a filename is decoded only once
+		{"s3://my-bucket/pool/main/f/foo/foo_1%253a2.0-1_amd64.deb", "my-bucket", "pool/main/f/foo/foo_1%3a2.0-1_amd64.deb"},
+		{"s3://my-bucket/pool/main/f/foo/foo%20bar_1.0_all.deb", "my-bucket", "pool/main/f/foo/foo bar_1.0_all.deb"},
+		{"s3://my-bucket/pool/main/f/foo/foo%23bar_1.0_all.deb", "my-bucket", "pool/main/f/foo/foo#bar_1.0_all.deb"},
+		{"s3://my-bucket/pool/main/f/foo/foo%3Fbar_1.0_all.deb?versionId=v1", "my-bucket", "pool/main/f/foo/foo?bar_1.0_all.deb"},
+		{"s3://my-bucket/dists/stable/main/binary-amd64/by-hash/SHA256/53ce64325a38", "my-bucket", "dists/stable/main/binary-amd64/by-hash/SHA256/53ce64325a38"},
+		{"s3://arn:aws:s3:us-west-2:123456789012:accesspoint/my-ap/pool/foo_2.0%2Bdfsg-1_amd64.deb", "arn:aws:s3:us-west-2:123456789012:accesspoint/my-ap", "pool/foo_2.0+dfsg-1_amd64.deb"},
+	}
+
+	for _, t_ := range tt {
+		header := map[string][]string{
+			"URI":      {t_.uri},
+			"Filename": {filepath.Join(t.TempDir(), "key")},
+		}
+
+		var buf strings.Builder
+		ctx := log.Logger.WithContext(context.Background())
+		api := &MockS3API{
+			Body:          io.NopCloser(strings.NewReader("apt body")),
+			ContentLength: 8,
+		}
+		err := apttransports3go.Fetch(ctx, &buf, api, apttransports3go.NewConfig(), header)
+
+		assert.NoError(err)
+		assert.Equal(t_.bucket, *api.GetObjectInput.Bucket, t_.uri)
+		assert.Equal(t_.key, *api.GetObjectInput.Key, t_.uri)
+		// apt must see the URI as it was sent
+		assert.Contains(buf.String(), "URI: "+t_.uri+"\n")
+	}
+}
+
+func TestFetch_BadEncodedKey(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, uri := range []string{
+		"s3://my-bucket/pool/main/f/foo/foo_100%_all.deb",
+		"s3://my-bucket/pool/main/f/foo/foo_1%zz_all.deb",
+		"s3:///key",
+		"http://my-bucket/key",
+	} {
+		header := map[string][]string{
+			"URI":      {uri},
+			"Filename": {"/tmp/key"},
+		}
+
+		var buf strings.Builder
+		ctx := log.Logger.WithContext(context.Background())
+		api := &MockS3API{}
+		err := apttransports3go.Fetch(ctx, &buf, api, apttransports3go.NewConfig(), header)
+		assert.NoError(err, uri)
+		assert.Contains(buf.String(), "400 URI Failure\nFailReason: BadURI\nMessage: bad URI: ", uri)
+		assert.Contains(buf.String(), "URI: "+uri+"\n", uri)
+		assert.Nil(api.GetObjectInput, uri)
+	}
+}
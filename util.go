@@ -3,6 +3,13 @@ package apttransports3go
 import (
 	"bufio"
 	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 func readLine(r *bufio.Reader) (string, error) {
@@ -21,3 +28,85 @@ func readLine(r *bufio.Reader) (string, error) {
 		}
 	}
 }
+
+func isNotModified(err error) bool {
+	var respErr interface{ HTTPStatusCode() int }
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotModified
+}
+
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (sw *syncWriter) Write(p []byte) (int, error) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.w.Write(p)
+}
+
+// parseBool parses a boolean value in the same way as apt's configuration.
+func parseBool(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "1", "yes", "true", "with", "on", "enable":
+		return true, nil
+	case "0", "no", "false", "without", "off", "disable":
+		return false, nil
+	default:
+		return false, fmt.Errorf("invalid boolean: %s", s)
+	}
+}
+
+// parseSize parses a size in bytes with an optional binary suffix (K, M or G).
+func parseSize(s string) (int64, error) {
+	multipliers := map[string]int64{"K": 1 << 10, "M": 1 << 20, "G": 1 << 30}
+	unit := int64(1)
+	upper := strings.TrimSuffix(strings.ToUpper(s), "B")
+
+	if m, ok := multipliers[upper[max(len(upper)-1, 0):]]; ok {
+		unit = m
+		upper = upper[:len(upper)-1]
+	}
+
+	n, err := strconv.ParseInt(upper, 10, 64)
+
+	if err != nil {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+
+	return n * unit, nil
+}
+
+func formatSize(n int64) string {
+	if n < 1<<10 {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	units := []string{"KiB", "MiB", "GiB", "TiB"}
+	f := float64(n) / (1 << 10)
+	i := 0
+
+	for ; f >= 1<<10 && i < len(units)-1; i++ {
+		f /= 1 << 10
+	}
+
+	return fmt.Sprintf("%.1f %s", f, units[i])
+}
+
+var errTooLarge = errors.New("data is larger than the declared size")
+
+// limitWriter fails instead of writing more than n bytes.
+type limitWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (lw *limitWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > lw.n {
+		return 0, errTooLarge
+	}
+
+	n, err := lw.w.Write(p)
+	lw.n -= int64(n)
+	return n, err
+}
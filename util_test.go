@@ -2,6 +2,8 @@ package apttransports3go_test
 
 import (
 	"bufio"
+	"errors"
+	"fmt"
 	"io"
 	"strings"
 	"testing"
@@ -31,3 +33,104 @@ func TestReadLine_OK(t *testing.T) {
 		assert.Equal(t.err, err)
 	}
 }
+
+func TestIsNotModified_OK(t *testing.T) {
+	assert := assert.New(t)
+
+	tt := []struct {
+		err      error
+		expected bool
+	}{
+		{nil, false},
+		{errors.New("error"), false},
+		{newResponseError(304, errors.New("NotModified")), true},
+		{fmt.Errorf("wrapped: %w", newResponseError(304, errors.New("NotModified"))), true},
+		{newResponseError(404, errors.New("NotFound")), false},
+	}
+
+	for _, t := range tt {
+		assert.Equal(t.expected, apttransports3go.IsNotModified(t.err))
+	}
+}
+
+func TestParseBool_OK(t *testing.T) {
+	assert := assert.New(t)
+
+	tt := []struct {
+		value    string
+		expected bool
+	}{
+		{"true", true},
+		{"Yes", true},
+		{"1", true},
+		{"on", true},
+		{"false", false},
+		{"No", false},
+		{"0", false},
+		{"off", false},
+	}
+
+	for _, t := range tt {
+		actual, err := apttransports3go.ParseBool(t.value)
+		assert.NoError(err)
+		assert.Equal(t.expected, actual)
+	}
+}
+
+func TestParseBool_NG(t *testing.T) {
+	assert := assert.New(t)
+	_, err := apttransports3go.ParseBool("maybe")
+	assert.EqualError(err, "invalid boolean: maybe")
+}
+
+func TestParseSize_OK(t *testing.T) {
+	assert := assert.New(t)
+
+	tt := []struct {
+		value    string
+		expected int64
+	}{
+		{"100", 100},
+		{"100B", 100},
+		{"4K", 4096},
+		{"16M", 16 * 1024 * 1024},
+		{"16mb", 16 * 1024 * 1024},
+		{"1G", 1024 * 1024 * 1024},
+	}
+
+	for _, t := range tt {
+		actual, err := apttransports3go.ParseSize(t.value)
+		assert.NoError(err)
+		assert.Equal(t.expected, actual)
+	}
+}
+
+func TestParseSize_NG(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, value := range []string{"", "M", "1T", "x"} {
+		_, err := apttransports3go.ParseSize(value)
+		assert.EqualError(err, "invalid size: "+value)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	assert := assert.New(t)
+
+	tt := []struct {
+		value    int64
+		expected string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{16 * 1024 * 1024, "16.0 MiB"},
+		{3 * 1024 * 1024 * 1024, "3.0 GiB"},
+		{5 * 1024 * 1024 * 1024 * 1024 * 1024, "5120.0 TiB"},
+	}
+
+	for _, t := range tt {
+		assert.Equal(t.expected, apttransports3go.FormatSize(t.value))
+	}
+}